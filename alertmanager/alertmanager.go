@@ -7,6 +7,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dbsystel/alertmanager-config-controller/event"
+	"github.com/dbsystel/alertmanager-config-controller/policy"
+	"github.com/dbsystel/alertmanager-config-controller/sink"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 )
@@ -17,16 +20,35 @@ type APIClient struct {
 	ConfigPath     string
 	ConfigTemplate string
 	HTTPClient     *http.Client
-	ID             int
+	ID             string
 	Key            string
-	logger         log.Logger
+	HistoryLimit   int
+	Sink           sink.Sink
+	Policy         *policy.Policy
+	Events         event.Recorder
+	// AllowPlainKey accepts the plain key in alertmanager.net/key instead of a signature
+	AllowPlainKey bool
+	// Substitute ${NAME} placeholders in fragments with Vars
+	Substitute bool
+	Vars       map[string]string
+	// Env lists the environment variables the template of alertmanager.yml may read with env
+	Env []string
+	// NamespaceRoutes generates default routes to the team receivers of namespaces
+	NamespaceRoutes bool
+	logger          log.Logger
 }
 
-// Config of alertmanager
+// Config of alertmanager, the data of the template of alertmanager.yml
 type Config struct {
 	Receivers    string
 	Routes       string
 	InhibitRules string
+	// ID of the Alertmanager setup
+	ID string
+	// Namespaces with active routes, receivers or inhibit rules
+	Namespaces []string
+	// ReceiverNames of all receivers taken from configmaps
+	ReceiverNames []string
 }
 
 // Reload alertmanager
@@ -68,7 +90,7 @@ func (c *APIClient) doPost(url string) (int, error) {
 }
 
 // New return an APIClient
-func New(baseURL *url.URL, configPath string, configTemplate string, id int, key string, logger log.Logger) *APIClient {
+func New(baseURL *url.URL, configPath string, configTemplate string, id string, key string, logger log.Logger) *APIClient {
 	return &APIClient{
 		URL:            baseURL,
 		ConfigPath:     configPath,
@@ -76,6 +98,7 @@ func New(baseURL *url.URL, configPath string, configTemplate string, id int, key
 		HTTPClient:     http.DefaultClient,
 		ID:             id,
 		Key:            key,
+		Sink:           sink.NewFile(configPath + "/alertmanager.yml"),
 		logger:         logger,
 	}
 }
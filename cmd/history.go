@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	"github.com/dbsystel/alertmanager-config-controller/history"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	alcf "github.com/prometheus/alertmanager/config"
+)
+
+// print all kept generations of alertmanager.yml
+func listHistory(out io.Writer, store *history.Store) error {
+	generations, err := store.List()
+	if err != nil {
+		return fmt.Errorf("failed to read history: %s", err)
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "GENERATION\tTIMESTAMP\tTRIGGER\tHASH")
+	for _, g := range generations {
+		fmt.Fprintln(w, strconv.Itoa(g.ID)+"\t"+g.Timestamp.Format(time.RFC3339)+"\t"+g.Trigger+"\t"+shortHash(g.Hash))
+	}
+	return w.Flush()
+}
+
+// the first characters of a content hash, like git abbreviates commits
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+// print a unified diff between two generations of alertmanager.yml
+func diffHistory(out io.Writer, store *history.Store, from, to int) error {
+	diff, err := store.Diff(from, to)
+	if err != nil {
+		return fmt.Errorf("failed to diff generations: %s", err)
+	}
+	_, err = fmt.Fprint(out, diff)
+	return err
+}
+
+// write a generation back to alertmanager.yml and reload Alertmanager, the restore is kept as new generation
+func restoreHistory(store *history.Store, generation int, a *alertmanager.APIClient, logger log.Logger) error {
+	config, err := store.Read(generation)
+	if err != nil {
+		return fmt.Errorf("failed to restore generation: %s", err)
+	}
+	// a restored config is checked like a rendered one before it is published
+	_, err = alcf.Load(string(config))
+	if err != nil {
+		return fmt.Errorf("generation %d is no valid config: %s", generation, err)
+	}
+	err = a.Sink.Write(config)
+	if err != nil {
+		return fmt.Errorf("failed to write alertmanager.yml to %s: %s", a.Sink.String(), err)
+	}
+	//nolint:errcheck
+	level.Info(logger).Log("msg", "Restored alertmanager.yml", "generation", generation)
+
+	if a.URL != nil {
+		_, err = a.Reload()
+		if err != nil {
+			return fmt.Errorf("failed to reload Alertmanager: %s", err)
+		}
+		//nolint:errcheck
+		level.Info(logger).Log("msg", "Succeeded: Reloaded Alertmanager")
+	}
+
+	_, err = store.Save(config, "restore of generation "+strconv.Itoa(generation))
+	if err != nil {
+		return fmt.Errorf("failed to save restored generation to history: %s", err)
+	}
+	return nil
+}
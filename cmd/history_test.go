@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	"github.com/dbsystel/alertmanager-config-controller/history"
+	"github.com/go-kit/kit/log"
+)
+
+var (
+	historyConfig1 = "route:\n  receiver: dummy\nreceivers:\n- name: dummy\n"
+	historyConfig2 = "route:\n  receiver: team-a\nreceivers:\n- name: team-a\n"
+)
+
+func TestShortHash(t *testing.T) {
+	cases := []struct {
+		hash string
+		want string
+	}{
+		{"", ""},
+		{"abc", "abc"},
+		{"0123456789ab", "0123456789ab"},
+		{"0123456789abcdef", "0123456789ab"},
+	}
+	for _, tc := range cases {
+		if got := shortHash(tc.hash); got != tc.want {
+			t.Errorf("shortHash(%q) = %q, want %q", tc.hash, got, tc.want)
+		}
+	}
+}
+
+// a history store in a temporary directory with the configs as generations 1, 2, ...
+func newTestHistory(t *testing.T, configs ...string) (*history.Store, string, func()) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := history.New(filepath.Join(dir, "history"), 10)
+	for i, config := range configs {
+		if _, err = store.Save([]byte(config), "team-a/"+string(rune('a'+i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, dir, func() { os.RemoveAll(dir) }
+}
+
+func TestListHistory(t *testing.T) {
+	cases := []struct {
+		name    string
+		configs []string
+		// lines of the output without the timestamps
+		want []string
+	}{
+		{"empty", nil, []string{"GENERATION TIMESTAMP TRIGGER HASH"}},
+		{"two generations", []string{historyConfig1, historyConfig2}, []string{
+			"GENERATION TIMESTAMP TRIGGER HASH",
+			"1 team-a/a",
+			"2 team-a/b",
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store, _, cleanup := newTestHistory(t, tc.configs...)
+			defer cleanup()
+
+			var out bytes.Buffer
+			if err := listHistory(&out, store); err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if len(lines) != len(tc.want) {
+				t.Fatalf("output = %q, want %d lines", out.String(), len(tc.want))
+			}
+			for i, line := range lines {
+				fields := strings.Fields(line)
+				if i > 0 {
+					// drop the timestamp and the hash
+					fields = []string{fields[0], fields[2]}
+				}
+				if got := strings.Join(fields, " "); got != tc.want[i] {
+					t.Errorf("line %d = %q, want %q", i, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDiffHistory(t *testing.T) {
+	cases := []struct {
+		name     string
+		from, to int
+		// output contains
+		want []string
+		// error contains, empty if the diff succeeds
+		err string
+	}{
+		{"changed", 1, 2, []string{"--- generation 1", "+++ generation 2", "-  receiver: dummy", "+  receiver: team-a"}, ""},
+		{"unchanged", 1, 1, nil, ""},
+		{"unknown generation", 1, 3, nil, "generation 3 not found"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store, _, cleanup := newTestHistory(t, historyConfig1, historyConfig2)
+			defer cleanup()
+
+			var out bytes.Buffer
+			err := diffHistory(&out, store, tc.from, tc.to)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("err = %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("diff does not contain %q:\n%s", want, out.String())
+				}
+			}
+			if tc.want == nil && out.Len() != 0 {
+				t.Errorf("diff = %q, want none", out.String())
+			}
+		})
+	}
+}
+
+func TestRestoreHistory(t *testing.T) {
+	cases := []struct {
+		name       string
+		configs    []string
+		generation int
+		// status of the reload, 0 without Alertmanager
+		status int
+		// alertmanager.yml after the restore, empty if it is not written
+		config string
+		// trigger of the newest generation after the restore
+		trigger string
+		// error contains, empty if the restore succeeds
+		err string
+	}{
+		{
+			name:       "restore without Alertmanager",
+			configs:    []string{historyConfig1, historyConfig2},
+			generation: 1,
+			config:     historyConfig1,
+			trigger:    "restore of generation 1",
+		},
+		{
+			name:       "restore and reload",
+			configs:    []string{historyConfig1, historyConfig2},
+			generation: 1,
+			status:     http.StatusOK,
+			config:     historyConfig1,
+			trigger:    "restore of generation 1",
+		},
+		{
+			name:       "unknown generation",
+			configs:    []string{historyConfig1},
+			generation: 5,
+			trigger:    "team-a/a",
+			err:        "generation 5 not found",
+		},
+		{
+			name:       "invalid generation",
+			configs:    []string{historyConfig1, "route:\n  receiver: missing\n"},
+			generation: 2,
+			trigger:    "team-a/b",
+			err:        "generation 2 is no valid config",
+		},
+		{
+			name:       "refused reload",
+			configs:    []string{historyConfig1, historyConfig2},
+			generation: 1,
+			status:     http.StatusBadRequest,
+			config:     historyConfig1,
+			trigger:    "team-a/b",
+			err:        "failed to reload Alertmanager",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store, dir, cleanup := newTestHistory(t, tc.configs...)
+			defer cleanup()
+			var URL *url.URL
+			if tc.status != 0 {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(tc.status)
+				}))
+				defer server.Close()
+				URL, _ = url.Parse(server.URL + "/-/reload")
+			}
+			a := alertmanager.New(URL, dir, dir+"/alertmanager.tmpl", "0", "key", log.NewNopLogger())
+
+			err := restoreHistory(store, tc.generation, a, log.NewNopLogger())
+			if tc.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("err = %v, want %q", err, tc.err)
+			}
+			config, _ := a.Sink.Read()
+			if string(config) != tc.config {
+				t.Errorf("alertmanager.yml = %q, want %q", config, tc.config)
+			}
+			generations, err := store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if newest := generations[len(generations)-1]; newest.Trigger != tc.trigger {
+				t.Errorf("newest generation = %+v, want trigger %q", newest, tc.trigger)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/dbsystel/alertmanager-config-controller/setup"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// call started whenever this replica acquires the lease and stopped when it loses it, until stop is closed
+func runWithLeaderElection(k8sClient *kubernetes.Clientset, config setup.LeaderElection, stop <-chan struct{}, started func(), stopped func(), logger log.Logger) {
+	identity, err := os.Hostname()
+	if err != nil {
+		//nolint:errcheck
+		level.Error(logger).Log("msg", "Failed to determine identity for leader election", "err", err.Error())
+		os.Exit(2)
+	}
+
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		config.Namespace,
+		config.Name,
+		k8sClient.CoreV1(),
+		k8sClient.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: identity},
+	)
+	if err != nil {
+		//nolint:errcheck
+		level.Error(logger).Log("msg", "Failed to create leader election lock", "err", err.Error())
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				//nolint:errcheck
+				level.Info(logger).Log("msg", "Started leading", "identity", identity)
+				started()
+			},
+			OnStoppedLeading: func() {
+				//nolint:errcheck
+				level.Debug(logger).Log("msg", "Leader election term ended", "identity", identity)
+				stopped()
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					//nolint:errcheck
+					level.Info(logger).Log("msg", "Waiting as standby", "leader", leader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		//nolint:errcheck
+		level.Error(logger).Log("msg", "Failed to create leader elector", "err", err.Error())
+		os.Exit(2)
+	}
+
+	// stand by for the next term after losing the lease
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+}
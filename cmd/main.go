@@ -2,32 +2,73 @@ package main
 
 import (
 	"fmt"
-	"net/url"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"sync"
 	"syscall"
 
-	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
-	"github.com/dbsystel/alertmanager-config-controller/controller"
-	"github.com/dbsystel/kube-controller-dbsystel-go-common/controller/configmap"
+	"github.com/dbsystel/alertmanager-config-controller/history"
+	"github.com/dbsystel/alertmanager-config-controller/setup"
 	"github.com/dbsystel/kube-controller-dbsystel-go-common/kubernetes"
 	k8sflag "github.com/dbsystel/kube-controller-dbsystel-go-common/kubernetes/flag"
 	opslog "github.com/dbsystel/kube-controller-dbsystel-go-common/log"
 	logflag "github.com/dbsystel/kube-controller-dbsystel-go-common/log/flag"
 	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/alecthomas/kingpin.v2"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 var (
 	app = kingpin.New(filepath.Base(os.Args[0]), "Alertmanager Controller")
 	//Here you can define more flags for your application
-	configPath     = app.Flag("config-path", "The location to save rule and config files to").Required().String()
-	configTemplate = app.Flag("config-template", "The template of alertmanager.yml").Required().String()
-	id             = app.Flag("id", "The id of Alertmanager").Default("0").Int()
-	key            = app.Flag("key", "The unique key for alertmanager config").String()
-	reloadURL      = app.Flag("reload-url", "The url to issue requests to reload Alertmanager to").Required().String()
+	configPath      = app.Flag("config-path", "The location to save rule and config files to").String()
+	configTemplate  = app.Flag("config-template", "The template of alertmanager.yml").String()
+	id              = app.Flag("id", "The id of the Alertmanager setup, e.g. platform").Default("0").String()
+	key             = app.Flag("key", "The unique key for alertmanager config").String()
+	keyFile         = app.Flag("key-file", "The file with the unique key for alertmanager config, e.g. a mounted secret").String()
+	allowPlainKey   = app.Flag("allow-plain-key", "Accept configmaps carrying the plain key in alertmanager.net/key instead of a signature").Bool()
+	reloadURL       = app.Flag("reload-url", "The url to issue requests to reload Alertmanager to, no reload if empty").String()
+	historyLimit    = app.Flag("history-limit", "The number of rendered alertmanager.yml generations to keep").Default("10").Int()
+	listenAddress   = app.Flag("listen-address", "The address to serve metrics and the read-only debug API on, disabled if empty").String()
+	policyFile      = app.Flag("policy-file", "The YAML file with the policy for admitted configmaps").String()
+	substitute      = app.Flag("substitute", "Replace ${NAME} placeholders in routes, receivers and inhibit rules with the values of variables").Bool()
+	substituteEnv   = app.Flag("substitute-env", "An environment variable which may be used in placeholders, may be repeated").Strings()
+	valuesFile      = app.Flag("values-file", "The YAML file with the values of variables used in placeholders").String()
+	namespaceRoutes = app.Flag("namespace-routes", "Generate a default route for every namespace with the alertmanager.net/team-receiver annotation or label").Bool()
+
+	controllerConfig         = app.Flag("controller-config", "The YAML file with the config of the controller and all Alertmanager setups served by it, replaces the flags of a single setup").String()
+	controllerConfigInterval = app.Flag("controller-config-interval", "The interval to check the controller config file for changes").Default("10s").Duration()
+
+	watchNamespaces = app.Flag("watch-namespace", "A namespace to watch configmaps in, may be repeated (default all namespaces)").Strings()
+	labelSelector   = app.Flag("label-selector", "Only watch configmaps matching this label selector, e.g. alertmanager.net/managed=true").String()
+
+	output          = app.Flag("output", "Where to publish the rendered alertmanager.yml").Default("file").Enum("file", "secret", "configmap")
+	outputNamespace = app.Flag("output-namespace", "The namespace of the output secret or configmap").Default("default").String()
+	outputName      = app.Flag("output-name", "The name of the output secret or configmap").Default("alertmanager-config").String()
+	outputKey       = app.Flag("output-key", "The key of alertmanager.yml in the output secret or configmap").Default("alertmanager.yml").String()
+
+	leaderElect                 = app.Flag("leader-elect", "Only render and publish alertmanager.yml while holding a lease").Bool()
+	leaderElectionNamespace     = app.Flag("leader-election-namespace", "The namespace of the leader election lease").Default("default").String()
+	leaderElectionName          = app.Flag("leader-election-name", "The name of the leader election lease").Default("alertmanager-config-controller").String()
+	leaderElectionLeaseDuration = app.Flag("leader-election-lease-duration", "The duration standbys wait before taking over a lease").Default("15s").Duration()
+	leaderElectionRenewDeadline = app.Flag("leader-election-renew-deadline", "The duration the leader retries renewing the lease").Default("10s").Duration()
+	leaderElectionRetryPeriod   = app.Flag("leader-election-retry-period", "The duration between tries to acquire or renew the lease").Default("2s").Duration()
+
+	runCmd = app.Command("run", "Run the controller").Default()
+
+	historyCmd         = app.Command("history", "Inspect and restore rendered alertmanager.yml generations")
+	historyListCmd     = historyCmd.Command("list", "List all kept generations")
+	historyDiffCmd     = historyCmd.Command("diff", "Show a unified diff between two generations")
+	historyDiffFrom    = historyDiffCmd.Arg("from", "The older generation").Required().Int()
+	historyDiffTo      = historyDiffCmd.Arg("to", "The newer generation").Required().Int()
+	historyRestoreCmd  = historyCmd.Command("restore", "Restore a generation and reload Alertmanager")
+	historyRestoreFrom = historyRestoreCmd.Arg("generation", "The generation to restore").Required().Int()
+
+	signCmd  = app.Command("sign", "Print the alertmanager.net/signature annotation of a config configmap")
+	signFile = signCmd.Arg("file", "The YAML file of the configmap").Required().ExistingFile()
 )
 
 func main() {
@@ -39,7 +80,7 @@ func main() {
 	logflag.AddFlags(app, &logcfg)
 	k8sflag.AddFlags(app, &runOutsideCluster)
 	//Parse all arguments
-	_, err := app.Parse(os.Args[1:])
+	command, err := app.Parse(os.Args[1:])
 	if err != nil {
 		//Received error while parsing arguments from function app.Parse
 		fmt.Fprintln(os.Stderr, "Catched the following error while parsing arguments: ", err)
@@ -56,6 +97,72 @@ func main() {
 	//First usage of initialized logger for testing
 	//nolint:errcheck
 	level.Debug(logger).Log("msg", "Logging initiated...")
+
+	if command == signCmd.FullCommand() {
+		s := flagSetup()
+		if err = s.LoadKey(); err != nil {
+			fmt.Fprintln(os.Stderr, "Key could not be loaded: ", err)
+			os.Exit(2)
+		}
+		signConfigMap(*signFile, s.Key)
+		return
+	}
+
+	if *controllerConfig == "" || command != runCmd.FullCommand() {
+		if *configPath == "" {
+			fmt.Fprintln(os.Stderr, "Flag --config-path is required")
+			app.Usage(os.Args[1:])
+			os.Exit(2)
+		}
+	}
+
+	store := history.New(*configPath+"/history", *historyLimit)
+	config := flagConfig()
+	switch command {
+	case historyListCmd.FullCommand():
+		if err = listHistory(os.Stdout, store); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case historyDiffCmd.FullCommand():
+		if err = diffHistory(os.Stdout, store, *historyDiffFrom, *historyDiffTo); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case runCmd.FullCommand():
+		if *controllerConfig != "" {
+			config, err = setup.Load(*controllerConfig)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Controller config could not be loaded: ", err)
+				os.Exit(2)
+			}
+		} else {
+			if *configTemplate == "" {
+				fmt.Fprintln(os.Stderr, "Flag --config-template is required to run the controller")
+				app.Usage(os.Args[1:])
+				os.Exit(2)
+			}
+			if err = config.Setups[0].LoadKey(); err != nil {
+				fmt.Fprintln(os.Stderr, "Key could not be loaded: ", err)
+				os.Exit(2)
+			}
+			if err = config.Setups[0].LoadValues(); err != nil {
+				fmt.Fprintln(os.Stderr, "Values could not be loaded: ", err)
+				os.Exit(2)
+			}
+			if reason := setup.ValidateID(*id); reason != "" {
+				fmt.Fprintln(os.Stderr, "Invalid id "+*id+": ", reason)
+				os.Exit(2)
+			}
+			if _, err = labels.Parse(*labelSelector); err != nil {
+				fmt.Fprintln(os.Stderr, "Label selector could not be parsed: ", err)
+				os.Exit(2)
+			}
+		}
+	}
+
 	//Initialize new k8s client from common k8s package
 	k8sClient, err := kubernetes.NewClientSet(runOutsideCluster)
 	if err != nil {
@@ -65,15 +172,29 @@ func main() {
 		os.Exit(2)
 	}
 
-	URL, err := url.Parse(*reloadURL)
+	if command == historyRestoreCmd.FullCommand() {
+		a, err := newAPIClient(flagSetup(), k8sClient, logger)
+		if err != nil {
+			//nolint:errcheck
+			level.Error(logger).Log("msg", "Alertmanager setup could not be created", "err", err.Error())
+			os.Exit(2)
+		}
+		if err = restoreHistory(store, *historyRestoreFrom, a, logger); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	//Every setup has its own controller, all of them are fed by the same informer
+	r := newRunner(k8sClient, logger)
+	err = r.apply(config)
 	if err != nil {
 		//nolint:errcheck
-		level.Error(logger).Log("msg", "Alertmanager reload URL could not be parsed: "+*reloadURL)
+		level.Error(logger).Log("msg", "Alertmanager setup could not be created", "err", err.Error())
 		os.Exit(2)
 	}
 
-	a := alertmanager.New(URL, *configPath, *configTemplate, *id, *key, logger)
-
 	//nolint:errcheck
 	level.Info(logger).Log("msg", "Starting Alertmanager Controller...")
 	sigs := make(chan os.Signal, 1) // Create channel to receive OS signals
@@ -83,18 +204,50 @@ func main() {
 
 	wg := &sync.WaitGroup{} // Goroutines can add themselves to this to be waited on so that they finish
 
-	//Initialize new k8s configmap-controller from common k8s package
-	configMapController := &configmap.ConfigMapController{}
-	configMapController.Controller = controller.New(*a, logger)
-	configMapController.Initialize(k8sClient)
-	//Run initiated configmap-controller as go routine
-	go configMapController.Run(stop, wg)
+	if *controllerConfig != "" {
+		//Apply changes of the controller config file without a restart
+		go setup.Watch(*controllerConfig, *controllerConfigInterval, config, r.apply, logger, stop)
+	}
+
+	if config.LeaderElection.Enabled {
+		//Every replica keeps its informers warm, only the leader writes alertmanager.yml and reloads Alertmanager
+		r.standby()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runWithLeaderElection(k8sClient, config.LeaderElection, stop, r.startLeading, r.stopLeading, logger)
+		}()
+	}
+	wg.Add(1)
+	go r.run(stop, wg)
+
+	var server *http.Server
+	if config.ListenAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.Handle("/", r)
+		server = &http.Server{Addr: config.ListenAddress, Handler: mux}
+		go func() {
+			//nolint:errcheck
+			level.Info(logger).Log("msg", "Serving metrics and debug API", "address", config.ListenAddress)
+			err := server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				//nolint:errcheck
+				level.Error(logger).Log("msg", "Failed to serve metrics and debug API", "err", err.Error())
+			}
+		}()
+	}
 
 	<-sigs // Wait for signals (this hangs until a signal arrives)
 
 	//nolint:errcheck
 	level.Info(logger).Log("msg", "Shutting down...")
 
+	if server != nil {
+		//nolint:errcheck
+		server.Close()
+	}
+
 	close(stop) // Tell goroutines to stop themselves
 	wg.Wait()   // Wait for all to be stopped
 }
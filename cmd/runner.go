@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/dbsystel/alertmanager-config-controller/controller"
+	"github.com/dbsystel/alertmanager-config-controller/informer"
+	"github.com/dbsystel/alertmanager-config-controller/setup"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// runner feeds the controllers of all setups from one informer,
+// which is restarted when the watched configmaps change
+type runner struct {
+	k8sClient   kubernetes.Interface
+	logger      log.Logger
+	mu          sync.RWMutex
+	config      *setup.Config
+	controllers map[string]*controller.Controller
+	changed     chan struct{}
+	// controllers of added or changed setups, which are reconciled with the informer caches
+	added []*controller.Controller
+	// controllers of removed setups, whose fragments and config are removed
+	removed []*controller.Controller
+	// events of all informers, handled only while leading
+	queue *controller.Queue
+	// informers of the current controller config, informerStop is closed when they are restarted
+	configMaps   *informer.ConfigMapController
+	namespaces   *informer.NamespaceController
+	informerStop <-chan struct{}
+}
+
+func newRunner(k8sClient kubernetes.Interface, logger log.Logger) *runner {
+	return &runner{
+		k8sClient:   k8sClient,
+		logger:      logger,
+		controllers: make(map[string]*controller.Controller),
+		changed:     make(chan struct{}, 1),
+		queue:       controller.NewQueue(),
+	}
+}
+
+// apply a controller config, controllers of unchanged setups are kept together with their state,
+// those of changed setups start from the informer caches and removed setups are cleaned up
+func (r *runner) apply(config *setup.Config) error {
+	r.mu.RLock()
+	previous := r.config
+	previousControllers := r.controllers
+	r.mu.RUnlock()
+
+	controllers := make(map[string]*controller.Controller)
+	var added []*controller.Controller
+	for _, s := range config.Setups {
+		if c, ok := previousControllers[s.ID]; ok && previous != nil && reflect.DeepEqual(findSetup(previous, s.ID), &s) {
+			controllers[s.ID] = c
+			continue
+		}
+		a, err := newAPIClient(s, r.k8sClient, r.logger)
+		if err != nil {
+			return fmt.Errorf("setup %q: %s", s.ID, err)
+		}
+		controllers[s.ID] = controller.New(*a, r.logger)
+		added = append(added, controllers[s.ID])
+	}
+	var removed []*controller.Controller
+	for id, c := range previousControllers {
+		if findSetup(config, id) == nil {
+			removed = append(removed, c)
+		}
+	}
+
+	if previous != nil &&
+		(previous.ListenAddress != config.ListenAddress || previous.LeaderElection != config.LeaderElection) {
+		//nolint:errcheck
+		level.Warn(r.logger).Log("msg", "Changes of listen_address and leader_election are applied after a restart of the controller")
+	}
+
+	r.mu.Lock()
+	r.config = config
+	r.controllers = controllers
+	if previous != nil {
+		r.added = append(r.added, added...)
+		r.removed = append(r.removed, removed...)
+	}
+	r.mu.Unlock()
+
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// watch configmaps for the current controllers until stop is closed, the caller adds it to wg before starting it,
+// the events of all informers are handled one after another by a single worker
+func (r *runner) run(stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.queue.Run()
+	}()
+	defer r.queue.ShutDown()
+
+	// a pending change was applied before the informer is started
+	select {
+	case <-r.changed:
+	default:
+	}
+	dispatcher := &controller.Dispatcher{Queue: r.queue}
+	namespaceHandler := &controller.NamespaceHandler{Queue: r.queue}
+	var watching *setup.Config
+	var informerStop chan struct{}
+	for {
+		r.mu.RLock()
+		config := r.config
+		controllers := r.current()
+		r.mu.RUnlock()
+		dispatcher.SetControllers(controllers)
+		namespaceHandler.SetControllers(controllers)
+
+		if watching != nil && sameWatch(watching, config) {
+			// the informers keep running, only the controllers of changed setups start over
+			r.queue.Add(r.reconcileChanged)
+		} else {
+			if informerStop != nil {
+				//nolint:errcheck
+				level.Info(r.logger).Log("msg", "Restarting informer for changed controller config")
+				close(informerStop)
+			}
+			watching = config
+
+			configMapController := &informer.ConfigMapController{}
+			configMapController.Controller = dispatcher
+			configMapController.Namespaces = config.WatchNamespaces
+			configMapController.LabelSelector = config.LabelSelector
+			configMapController.Initialize(r.k8sClient)
+			informerStop = make(chan struct{})
+			wg.Add(1)
+			go configMapController.Run(informerStop, wg)
+			var namespaceController *informer.NamespaceController
+			if needsNamespaces(config) {
+				namespaceController = &informer.NamespaceController{}
+				namespaceController.Controller = namespaceHandler
+				namespaceController.Namespaces = config.WatchNamespaces
+				namespaceController.Initialize(r.k8sClient)
+				wg.Add(1)
+				go namespaceController.Run(informerStop, wg)
+			}
+			r.mu.Lock()
+			first := r.configMaps == nil
+			r.configMaps = configMapController
+			r.namespaces = namespaceController
+			r.informerStop = informerStop
+			r.mu.Unlock()
+			if !first {
+				// configmaps which are no longer watched are dropped as well
+				r.queue.Add(r.reconcile)
+			}
+		}
+
+		select {
+		case <-stop:
+			close(informerStop)
+			return
+		case <-r.changed:
+		}
+	}
+}
+
+// stand by until startLeading, the informers keep watching but nothing is written
+func (r *runner) standby() {
+	r.queue.Standby()
+}
+
+// handle the events again once this replica holds the lease,
+// starting with the configmaps which changed while standing by
+func (r *runner) startLeading() {
+	r.queue.Lead(r.reconcile)
+}
+
+func (r *runner) stopLeading() {
+	r.queue.Standby()
+}
+
+// clean up removed setups and rebuild the fragments of all controllers from the informer caches
+func (r *runner) reconcile() {
+	r.mu.Lock()
+	r.added = nil
+	controllers := r.current()
+	r.mu.Unlock()
+	r.removeSetups()
+	r.reconcileControllers(controllers)
+}
+
+// clean up removed setups and rebuild the fragments of added and changed setups from the informer caches
+func (r *runner) reconcileChanged() {
+	r.mu.Lock()
+	added := r.added
+	r.added = nil
+	r.mu.Unlock()
+	r.removeSetups()
+	r.reconcileControllers(added)
+}
+
+// remove the fragments and configs of the removed setups
+func (r *runner) removeSetups() {
+	r.mu.Lock()
+	removed := r.removed
+	r.removed = nil
+	r.mu.Unlock()
+	for _, c := range removed {
+		c.Remove()
+	}
+}
+
+// rebuild the fragments of those controllers from the informer caches, which are still current
+func (r *runner) reconcileControllers(controllers []*controller.Controller) {
+	r.mu.RLock()
+	configMaps := r.configMaps
+	namespaces := r.namespaces
+	stop := r.informerStop
+	current := make(map[*controller.Controller]bool)
+	for _, c := range r.controllers {
+		current[c] = true
+	}
+	r.mu.RUnlock()
+	if configMaps == nil || len(controllers) == 0 {
+		// the informers are not started yet, their initial list is handled as events
+		return
+	}
+
+	synced := []cache.InformerSynced{configMaps.HasSynced}
+	if namespaces != nil {
+		synced = append(synced, namespaces.HasSynced)
+	}
+	if !cache.WaitForCacheSync(stop, synced...) {
+		// the informers were restarted, the new ones hand all configmaps over as events
+		return
+	}
+	var namespaceObjs []interface{}
+	if namespaces != nil {
+		namespaceObjs = namespaces.List()
+	}
+	configMapObjs := configMaps.List()
+	for _, c := range controllers {
+		if current[c] {
+			c.Reconcile(configMapObjs, namespaceObjs)
+		}
+	}
+}
+
+// serve the debug API of a single setup under /, of several setups under /setups/<id>/
+func (r *runner) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	controllers := r.controllers
+	r.mu.RUnlock()
+
+	if len(controllers) == 1 {
+		for _, c := range controllers {
+			c.Handler().ServeHTTP(w, req)
+		}
+		return
+	}
+	id := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/setups/"), "/", 2)[0]
+	c, ok := controllers[id]
+	if !ok || !strings.HasPrefix(req.URL.Path, "/setups/") {
+		http.NotFound(w, req)
+		return
+	}
+	http.StripPrefix("/setups/"+id, c.Handler()).ServeHTTP(w, req)
+}
+
+// controllers in the order of the setups, the caller holds the lock
+func (r *runner) current() []*controller.Controller {
+	var controllers []*controller.Controller
+	for _, s := range r.config.Setups {
+		controllers = append(controllers, r.controllers[s.ID])
+	}
+	return controllers
+}
+
+// whether any setup needs the namespaces
+func needsNamespaces(config *setup.Config) bool {
+	for _, s := range config.Setups {
+		if s.NamespaceRoutes {
+			return true
+		}
+	}
+	return false
+}
+
+// whether both configs watch the same configmaps and namespaces
+func sameWatch(a *setup.Config, b *setup.Config) bool {
+	return reflect.DeepEqual(a.WatchNamespaces, b.WatchNamespaces) &&
+		a.LabelSelector == b.LabelSelector &&
+		needsNamespaces(a) == needsNamespaces(b)
+}
+
+func findSetup(config *setup.Config, id string) *setup.Setup {
+	for i := range config.Setups {
+		if config.Setups[i].ID == id {
+			return &config.Setups[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dbsystel/alertmanager-config-controller/informer"
+	"github.com/dbsystel/alertmanager-config-controller/setup"
+	"github.com/go-kit/kit/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var testTemplate = `route:
+  receiver: dummy
+  routes:
+  {{ .Routes }}
+receivers:
+- name: dummy
+{{ .Receivers }}
+inhibit_rules:
+{{ .InhibitRules }}
+`
+
+// a runner with a single setup watching namespaces on a fake cluster
+func newTestRunner(t *testing.T, namespaces ...string) (*runner, *fake.Clientset, func()) {
+	return newTestRunnerWith(t, []string{"0"}, namespaces)
+}
+
+// a runner with a setup for each id watching namespaces on a fake cluster
+func newTestRunnerWith(t *testing.T, ids []string, namespaces []string) (*runner, *fake.Clientset, func()) {
+	dir, err := ioutil.TempDir("", "runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := filepath.Join(dir, "alertmanager.tmpl")
+	if err = ioutil.WriteFile(template, []byte(testTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := &setup.Config{WatchNamespaces: namespaces}
+	for _, id := range ids {
+		configPath := filepath.Join(dir, id, "config")
+		if err = os.MkdirAll(configPath, 0766); err != nil {
+			t.Fatal(err)
+		}
+		config.Setups = append(config.Setups, setup.Setup{
+			ID:             id,
+			Key:            "key",
+			ConfigPath:     configPath,
+			ConfigTemplate: template,
+		})
+	}
+
+	client := fake.NewSimpleClientset()
+	r := newRunner(client, log.NewNopLogger())
+	if err = r.apply(config); err != nil {
+		t.Fatal(err)
+	}
+	return r, client, func() { os.RemoveAll(dir) }
+}
+
+func receiverConfigMap(namespace string, name string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: map[string]string{"alertmanager.net/receiver": "true"},
+		},
+		Data: map[string]string{
+			"receiver.yaml": "- name: " + namespace + "-" + name + "\n  webhook_configs:\n  - url: http://" + name + "\n",
+		},
+	}
+}
+
+// wait until condition holds, at most ten seconds
+func eventually(condition func() bool) bool {
+	for i := 0; i < 1000; i++ {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// configmaps of two namespaces are created at the same time, the per-namespace informers
+// hand them to one worker, so no published config misses a receiver of the other namespace
+func TestRunnerSerializesNamespaces(t *testing.T) {
+	namespaces := []string{"team-a", "team-b"}
+	r, client, cleanup := newTestRunner(t, namespaces...)
+	defer cleanup()
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go r.run(stop, wg)
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	const perNamespace = 10
+	var created sync.WaitGroup
+	for _, namespace := range namespaces {
+		created.Add(1)
+		go func(namespace string) {
+			defer created.Done()
+			for i := 0; i < perNamespace; i++ {
+				_, err := client.CoreV1().ConfigMaps(namespace).Create(receiverConfigMap(namespace, fmt.Sprintf("r%d", i)))
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}(namespace)
+	}
+	created.Wait()
+
+	// receivers missing in the published config
+	missing := func() []string {
+		config, _ := ioutil.ReadFile(filepath.Join(r.config.Setups[0].ConfigPath, "alertmanager.yml"))
+		var receivers []string
+		for _, namespace := range namespaces {
+			for i := 0; i < perNamespace; i++ {
+				receiver := fmt.Sprintf("- name: %s-r%d\n", namespace, i)
+				if !strings.Contains(string(config), receiver) {
+					receivers = append(receivers, receiver)
+				}
+			}
+		}
+		return receivers
+	}
+	if !eventually(func() bool { return len(missing()) == 0 }) {
+		t.Errorf("config misses receivers %v", missing())
+	}
+	if fragments := r.controllers["0"].Fragments(); len(fragments) != len(namespaces)*perNamespace {
+		t.Errorf("%d fragments, want %d", len(fragments), len(namespaces)*perNamespace)
+	}
+}
+
+// configmaps outside the watched namespaces are never seen
+func TestRunnerWatchesNamespaces(t *testing.T) {
+	r, client, cleanup := newTestRunner(t, "team-a")
+	defer cleanup()
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go r.run(stop, wg)
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	for _, namespace := range []string{"team-b", "team-a"} {
+		if _, err := client.CoreV1().ConfigMaps(namespace).Create(receiverConfigMap(namespace, "r")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := r.controllers["0"]
+	if !eventually(func() bool { return len(c.Fragments()) > 0 }) {
+		t.Fatal("configmap of the watched namespace was not handled")
+	}
+	time.Sleep(100 * time.Millisecond)
+	for _, f := range c.Fragments() {
+		if f.Namespace != "team-a" {
+			t.Errorf("fragment %s of unwatched namespace %s", f.File, f.Namespace)
+		}
+	}
+}
+
+// a standby watches configmaps without writing anything, the leader publishes all of them
+func TestRunnerLeadership(t *testing.T) {
+	r, client, cleanup := newTestRunner(t)
+	defer cleanup()
+	r.standby()
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go r.run(stop, wg)
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	configFile := filepath.Join(r.config.Setups[0].ConfigPath, "alertmanager.yml")
+	config := func() string {
+		content, _ := ioutil.ReadFile(configFile)
+		return string(content)
+	}
+	// configmaps in the informer cache
+	cached := func() int {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		if r.configMaps == nil {
+			return 0
+		}
+		return len(r.configMaps.List())
+	}
+	create := func(namespace string, name string) {
+		if _, err := client.CoreV1().ConfigMaps(namespace).Create(receiverConfigMap(namespace, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	create("team-a", "first")
+	if !eventually(func() bool { return cached() == 1 }) {
+		t.Fatal("standby did not cache the configmap")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(configFile); !os.IsNotExist(err) {
+		t.Fatalf("standby wrote alertmanager.yml: %v", err)
+	}
+
+	r.startLeading()
+	if !eventually(func() bool { return strings.Contains(config(), "- name: team-a-first\n") }) {
+		t.Fatalf("leader did not publish the configmap cached as standby:\n%s", config())
+	}
+	create("team-a", "second")
+	if !eventually(func() bool { return strings.Contains(config(), "- name: team-a-second\n") }) {
+		t.Fatalf("leader did not publish a new configmap:\n%s", config())
+	}
+
+	r.stopLeading()
+	if err := client.CoreV1().ConfigMaps("team-a").Delete("first", &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	create("team-b", "third")
+	if !eventually(func() bool { return cached() == 2 }) {
+		t.Fatal("standby did not cache the changes")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !strings.Contains(config(), "- name: team-a-first\n") || strings.Contains(config(), "team-b-third") {
+		t.Fatalf("standby changed alertmanager.yml:\n%s", config())
+	}
+
+	r.startLeading()
+	if !eventually(func() bool {
+		return strings.Contains(config(), "- name: team-b-third\n") && !strings.Contains(config(), "team-a-first")
+	}) {
+		t.Fatalf("leader did not reconcile the changes made while standing by:\n%s", config())
+	}
+}
+
+// every setup picks up the configmaps targeting its id
+func TestRunnerSetups(t *testing.T) {
+	r, client, cleanup := newTestRunnerWith(t, []string{"platform", "team-payments"}, nil)
+	defer cleanup()
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go r.run(stop, wg)
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	for name, id := range map[string]string{"shared": "*", "platform": "platform", "payments": "team-payments"} {
+		configMap := receiverConfigMap("team-a", name)
+		configMap.Annotations["alertmanager.net/id"] = id
+		if _, err := client.CoreV1().ConfigMaps("team-a").Create(configMap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string][]string{
+		"platform":      {"team-a-platform", "team-a-shared"},
+		"team-payments": {"team-a-payments", "team-a-shared"},
+	}
+	for id, receivers := range want {
+		c := r.controllers[id]
+		if !eventually(func() bool { return len(c.Fragments()) == len(receivers) }) {
+			t.Fatalf("setup %s has fragments %v, want %v", id, c.Fragments(), receivers)
+		}
+		for i, f := range c.Fragments() {
+			if f.ConfigMap != strings.TrimPrefix(receivers[i], "team-a-") {
+				t.Errorf("setup %s has fragment %s of configmap %s, want %s", id, f.File, f.ConfigMap, receivers[i])
+			}
+		}
+	}
+}
+
+// applying a changed controller config keeps the controllers of unchanged setups
+func TestRunnerApply(t *testing.T) {
+	r, _, cleanup := newTestRunnerWith(t, []string{"platform", "team-payments"}, nil)
+	defer cleanup()
+	platform := r.controllers["platform"]
+	payments := r.controllers["team-payments"]
+
+	config := *r.config
+	config.Setups = append([]setup.Setup{}, r.config.Setups...)
+	config.Setups[1].HistoryLimit = 5
+	if err := r.apply(&config); err != nil {
+		t.Fatal(err)
+	}
+	if r.controllers["platform"] != platform {
+		t.Errorf("controller of the unchanged setup was replaced")
+	}
+	if r.controllers["team-payments"] == payments {
+		t.Errorf("controller of the changed setup was kept")
+	}
+
+	config.Setups = config.Setups[:1]
+	if err := r.apply(&config); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.controllers["team-payments"]; ok || len(r.controllers) != 1 {
+		t.Errorf("controllers = %v, want only the remaining setup", r.controllers)
+	}
+}
+
+// a changed setup starts from the informer cache, a removed one is cleaned up,
+// the informer is only restarted when the watched configmaps change
+func TestRunnerApplyWhileRunning(t *testing.T) {
+	r, client, cleanup := newTestRunnerWith(t, []string{"platform", "team-payments"}, []string{"team-a", "team-b"})
+	defer cleanup()
+	for _, namespace := range []string{"team-a", "team-b"} {
+		configMap := receiverConfigMap(namespace, "shared")
+		configMap.Annotations["alertmanager.net/id"] = "*"
+		if _, err := client.CoreV1().ConfigMaps(namespace).Create(configMap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go r.run(stop, wg)
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	fragments := func(id string) int {
+		r.mu.RLock()
+		c := r.controllers[id]
+		r.mu.RUnlock()
+		return len(c.Fragments())
+	}
+	informers := func() *informer.ConfigMapController {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.configMaps
+	}
+	if !eventually(func() bool { return fragments("platform") == 2 && fragments("team-payments") == 2 }) {
+		t.Fatal("configmaps were not handled")
+	}
+	platformPath := r.config.Setups[0].ConfigPath
+	paymentsPath := r.config.Setups[1].ConfigPath
+	// left behind by an earlier version of the setup
+	stale := filepath.Join(platformPath, "receivers", "team-c-stale-receiver.yaml")
+	if err := ioutil.WriteFile(stale, []byte("- name: stale\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	watching := informers()
+
+	config := *r.config
+	config.Setups = []setup.Setup{r.config.Setups[0]}
+	config.Setups[0].HistoryLimit = 5
+	if err := r.apply(&config); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(func() bool { return fragments("platform") == 2 }) {
+		t.Errorf("changed setup has %d fragments, want those of the informer cache", fragments("platform"))
+	}
+	if !eventually(func() bool {
+		_, err := os.Stat(stale)
+		return os.IsNotExist(err)
+	}) {
+		t.Errorf("stale fragment of the changed setup was kept")
+	}
+	if !eventually(func() bool {
+		_, err := os.Stat(filepath.Join(paymentsPath, "alertmanager.yml"))
+		return os.IsNotExist(err)
+	}) {
+		t.Errorf("config of the removed setup was kept")
+	}
+	if got, _ := filepath.Glob(filepath.Join(paymentsPath, "receivers", "*")); len(got) != 0 {
+		t.Errorf("fragments %v of the removed setup were kept", got)
+	}
+	if informers() != watching {
+		t.Errorf("informer was restarted although the watched configmaps did not change")
+	}
+
+	config.WatchNamespaces = []string{"team-a"}
+	if err := r.apply(&config); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(func() bool { return informers() != watching && fragments("platform") == 1 }) {
+		t.Errorf("setup has %d fragments, want only those of the watched namespace", fragments("platform"))
+	}
+}
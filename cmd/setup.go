@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/url"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	"github.com/dbsystel/alertmanager-config-controller/event"
+	"github.com/dbsystel/alertmanager-config-controller/policy"
+	"github.com/dbsystel/alertmanager-config-controller/setup"
+	"github.com/dbsystel/alertmanager-config-controller/sink"
+	"github.com/go-kit/kit/log"
+	"k8s.io/client-go/kubernetes"
+)
+
+// the controller config defined by the command line flags
+func flagConfig() *setup.Config {
+	return &setup.Config{
+		WatchNamespaces: *watchNamespaces,
+		LabelSelector:   *labelSelector,
+		ListenAddress:   *listenAddress,
+		LeaderElection: setup.LeaderElection{
+			Enabled:       *leaderElect,
+			Namespace:     *leaderElectionNamespace,
+			Name:          *leaderElectionName,
+			LeaseDuration: *leaderElectionLeaseDuration,
+			RenewDeadline: *leaderElectionRenewDeadline,
+			RetryPeriod:   *leaderElectionRetryPeriod,
+		},
+		Setups: []setup.Setup{flagSetup()},
+	}
+}
+
+// the setup defined by the command line flags
+func flagSetup() setup.Setup {
+	return setup.Setup{
+		ID:              *id,
+		Key:             *key,
+		KeyFile:         *keyFile,
+		AllowPlainKey:   *allowPlainKey,
+		ConfigPath:      *configPath,
+		ConfigTemplate:  *configTemplate,
+		ReloadURL:       *reloadURL,
+		HistoryLimit:    *historyLimit,
+		PolicyFile:      *policyFile,
+		Output:          *output,
+		OutputNamespace: *outputNamespace,
+		OutputName:      *outputName,
+		OutputKey:       *outputKey,
+		Substitute:      *substitute,
+		SubstituteEnv:   *substituteEnv,
+		ValuesFile:      *valuesFile,
+		NamespaceRoutes: *namespaceRoutes,
+	}
+}
+
+// create the client of the Alertmanager of a setup
+func newAPIClient(s setup.Setup, k8sClient kubernetes.Interface, logger log.Logger) (*alertmanager.APIClient, error) {
+	var URL *url.URL
+	var err error
+	if s.ReloadURL != "" {
+		URL, err = url.Parse(s.ReloadURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a := alertmanager.New(URL, s.ConfigPath, s.ConfigTemplate, s.ID, s.Key, logger)
+	a.HistoryLimit = s.HistoryLimit
+	a.AllowPlainKey = s.AllowPlainKey
+	a.Events = event.New(k8sClient)
+	a.Substitute = s.Substitute
+	a.Vars = s.Values
+	a.Env = s.SubstituteEnv
+	a.NamespaceRoutes = s.NamespaceRoutes
+	a.Policy = s.Policy
+	if a.Policy == nil && s.PolicyFile != "" {
+		a.Policy, err = policy.Load(s.PolicyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch s.Output {
+	case "secret":
+		a.Sink = sink.NewSecret(k8sClient, s.OutputNamespace, s.OutputName, s.OutputKey)
+	case "configmap":
+		a.Sink = sink.NewConfigMap(k8sClient, s.OutputNamespace, s.OutputName, s.OutputKey)
+	}
+	return a, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/dbsystel/alertmanager-config-controller/controller"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+)
+
+// the parts of a configmap manifest which are signed
+type configMapManifest struct {
+	Metadata struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+	Data map[string]string `yaml:"data"`
+}
+
+// print the signature of the configmap in file
+func signConfigMap(file string, key string) {
+	if key == "" {
+		fmt.Fprintln(os.Stderr, "Flag --key or --key-file is required to sign a configmap")
+		os.Exit(2)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read configmap: ", err)
+		os.Exit(1)
+	}
+	var manifest configMapManifest
+	err = yaml.Unmarshal(content, &manifest)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to parse configmap: ", err)
+		os.Exit(1)
+	}
+	if manifest.Metadata.Namespace == "" {
+		manifest.Metadata.Namespace = "default"
+	}
+	configmapObj := &v1.ConfigMap{Data: manifest.Data}
+	configmapObj.Name = manifest.Metadata.Name
+	configmapObj.Namespace = manifest.Metadata.Namespace
+	fmt.Println(controller.Signature(key, configmapObj))
+}
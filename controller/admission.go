@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dbsystel/alertmanager-config-controller/policy"
+	"github.com/go-kit/kit/log/level"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Rejection is a configmap which was not admitted by the controller
+type Rejection struct {
+	Namespace string    `json:"namespace"`
+	ConfigMap string    `json:"configmap"`
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason"`
+	Time      time.Time `json:"time"`
+}
+
+// check a configmap against the policy before any of its fragments is used,
+// returns the configmap with fragments corrected by clamped rules
+func (c *Controller) admit(configmapObj *v1.ConfigMap, configType string) (*v1.ConfigMap, bool) {
+	if configType == bundleConst {
+		for _, k := range unknownBundleKeys(configmapObj) {
+			//nolint:errcheck
+			level.Warn(c.logger).Log(
+				"msg", "Ignoring unknown bundle key: "+k,
+				"namespace", configmapObj.Namespace,
+				"name", configmapObj.Name,
+			)
+		}
+	}
+
+	if configType == configConst || configType == overlayConst {
+		var managers []string
+		for _, field := range configmapObj.ManagedFields {
+			managers = append(managers, field.Manager)
+		}
+		err := c.a.Policy.AllowManagers(managers)
+		if err != nil {
+			c.reject(configmapObj, configType, "manager", err.Error())
+			return configmapObj, false
+		}
+	}
+
+	admitted := configmapObj.DeepCopy()
+	var violations []string
+	for _, p := range parts(configmapObj, configType) {
+		resource := policyResource(p.configType)
+		err := c.a.Policy.AllowNamespace(resource, configmapObj.Namespace)
+		if err != nil {
+			c.reject(configmapObj, configType, "namespace", err.Error())
+			return configmapObj, false
+		}
+
+		result, err := c.a.Policy.Evaluate(resource, configmapObj.Namespace, p.content)
+		if err != nil {
+			violations = append(violations, p.key+": "+err.Error())
+			continue
+		}
+		for _, violation := range result.Violations {
+			violations = append(violations, p.key+": "+violation)
+		}
+		for _, correction := range result.Corrections {
+			//nolint:errcheck
+			level.Info(c.logger).Log(
+				"msg", "Corrected "+p.configType+": "+p.key,
+				"namespace", configmapObj.Namespace,
+				"name", configmapObj.Name,
+				"correction", correction,
+			)
+		}
+		admitted.Data[p.key] = result.Content
+	}
+	if len(violations) > 0 {
+		c.reject(configmapObj, configType, "policy", strings.Join(violations, "; "))
+		return configmapObj, false
+	}
+
+	c.forgetRejection(configmapObj)
+	return admitted, true
+}
+
+// log, count and remember a rejected configmap
+func (c *Controller) reject(configmapObj *v1.ConfigMap, configType string, cause string, reason string) {
+	//nolint:errcheck
+	level.Warn(c.logger).Log(
+		"msg", "Rejected "+configType,
+		"namespace", configmapObj.Namespace,
+		"name", configmapObj.Name,
+		"reason", reason,
+	)
+	rejectedConfigMaps.WithLabelValues(configType, cause).Inc()
+	if c.a.Events != nil {
+		err := c.a.Events.Warning(configmapObj, "Rejected", "Rejected "+configType+": "+reason)
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to record event", "err", err.Error())
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rejections[configmapObj.Namespace+"/"+configmapObj.Name] = Rejection{
+		Namespace: configmapObj.Namespace,
+		ConfigMap: configmapObj.Name,
+		Type:      configType,
+		Reason:    reason,
+		Time:      time.Now(),
+	}
+}
+
+// drop the rejection of a configmap, which was admitted or deleted
+func (c *Controller) forgetRejection(configmapObj *v1.ConfigMap) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.rejections, configmapObj.Namespace+"/"+configmapObj.Name)
+}
+
+// Rejections lists all currently rejected configmaps
+func (c *Controller) Rejections() []Rejection {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rejections := make([]Rejection, 0, len(c.rejections))
+	for _, r := range c.rejections {
+		rejections = append(rejections, r)
+	}
+	sort.Slice(rejections, func(i, j int) bool {
+		return rejections[i].Namespace+"/"+rejections[i].ConfigMap < rejections[j].Namespace+"/"+rejections[j].ConfigMap
+	})
+	return rejections
+}
+
+// name of a config type in the policy
+func policyResource(configType string) string {
+	switch configType {
+	case routeConst:
+		return policy.Route
+	case receiverConst:
+		return policy.Receiver
+	case inhibitRuleConst:
+		return policy.InhibitRule
+	case configConst, overlayConst:
+		return policy.Config
+	default:
+		return ""
+	}
+}
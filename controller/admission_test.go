@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	"github.com/dbsystel/alertmanager-config-controller/policy"
+	"github.com/prometheus/common/model"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAdmitNamespaces(t *testing.T) {
+	p := &policy.Policy{Namespaces: map[string]policy.NamespaceRule{
+		policy.Route:    {Allow: []string{"team-*"}},
+		policy.Receiver: {Deny: []string{"kube-*"}},
+	}}
+	cases := []struct {
+		name      string
+		configMap *v1.ConfigMap
+		// reason of the rejection, empty if admitted
+		reason string
+	}{
+		{"allowed route", routeConfigMap("team-a", "r", "dummy"), ""},
+		{"route of a namespace not allowed", routeConfigMap("default", "r", "dummy"), "namespace default is not allowed to contribute route"},
+		{"receiver", receiverConfigMap("default", "r", "r"), ""},
+		{"denied receiver", receiverConfigMap("kube-system", "r", "r"), "namespace kube-system is denied to contribute receiver"},
+		{
+			"bundle with a denied part",
+			configMap("kube-system", "b", map[string]string{
+				"routes.yaml":    "- receiver: b\n",
+				"receivers.yaml": "- name: b\n",
+			}, "alertmanager.net/bundle", "true"),
+			"namespace kube-system is denied to contribute receiver",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+				a.Policy = p
+			})
+			defer s.close()
+			s.c.Create(tc.configMap)
+
+			rejections := s.c.Rejections()
+			if tc.reason == "" {
+				if len(rejections) != 0 {
+					t.Errorf("rejections = %v, want none", rejections)
+				}
+				if len(s.c.Fragments()) == 0 {
+					t.Errorf("admitted configmap has no fragments")
+				}
+				return
+			}
+			if len(rejections) != 1 || rejections[0].Reason != tc.reason {
+				t.Fatalf("rejections = %v, want %q", rejections, tc.reason)
+			}
+			if len(s.c.Fragments()) != 0 {
+				t.Errorf("rejected configmap has fragments %v", s.c.Fragments())
+			}
+		})
+	}
+}
+
+func TestRejectionIsForgotten(t *testing.T) {
+	s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+		a.Policy = &policy.Policy{Namespaces: map[string]policy.NamespaceRule{
+			policy.Receiver: {Deny: []string{"team-a"}},
+		}}
+	})
+	defer s.close()
+	denied := receiverConfigMap("team-a", "r", "r")
+	s.c.Create(denied)
+	if len(s.c.Rejections()) != 1 {
+		t.Fatalf("rejections = %v, want one", s.c.Rejections())
+	}
+	s.c.Delete(denied)
+	if len(s.c.Rejections()) != 0 {
+		t.Errorf("rejections after delete = %v, want none", s.c.Rejections())
+	}
+}
+
+// recorder remembering the events instead of creating them
+type eventRecorder struct {
+	events []string
+}
+
+func (r *eventRecorder) Warning(configmapObj *v1.ConfigMap, reason string, message string) error {
+	r.events = append(r.events, configmapObj.Namespace+"/"+configmapObj.Name+" "+reason+": "+message)
+	return nil
+}
+
+func TestAdmitConfig(t *testing.T) {
+	p := &policy.Policy{
+		Namespaces: map[string]policy.NamespaceRule{policy.Config: {Allow: []string{"monitoring"}}},
+		Config:     &policy.ConfigRule{Managers: []string{"helm"}},
+	}
+	managed := func(configmapObj *v1.ConfigMap, managers ...string) *v1.ConfigMap {
+		for _, manager := range managers {
+			configmapObj.ManagedFields = append(configmapObj.ManagedFields, metav1.ManagedFieldsEntry{Manager: manager})
+		}
+		return signed(configmapObj)
+	}
+	cases := []struct {
+		name      string
+		configMap *v1.ConfigMap
+		// event recorded for the configmap, empty if it is admitted
+		event string
+	}{
+		{"allowed namespace and manager", managed(configConfigMap("monitoring"), "helm"), ""},
+		{
+			"other namespace",
+			managed(configConfigMap("team-a"), "helm"),
+			"team-a/config Rejected: Rejected config: namespace team-a is not allowed to contribute config",
+		},
+		{
+			"other manager",
+			managed(configConfigMap("monitoring"), "helm", "kubectl-edit"),
+			"monitoring/config Rejected: Rejected config: config is managed by kubectl-edit, allowed are [helm]",
+		},
+		{
+			"unknown managers",
+			managed(configConfigMap("monitoring")),
+			"monitoring/config Rejected: Rejected config: managers of the config are unknown, allowed are [helm]",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := &eventRecorder{}
+			s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+				a.Policy = p
+				a.Events = recorder
+			})
+			defer s.close()
+			s.c.Create(tc.configMap)
+
+			if tc.event == "" {
+				if len(recorder.events) != 0 || len(s.c.Rejections()) != 0 {
+					t.Errorf("events = %v, rejections = %v, want none", recorder.events, s.c.Rejections())
+				}
+				return
+			}
+			if !equalStrings(recorder.events, []string{tc.event}) {
+				t.Errorf("events = %v, want %q", recorder.events, tc.event)
+			}
+			if len(s.c.Rejections()) != 1 {
+				t.Errorf("rejections = %v, want one", s.c.Rejections())
+			}
+		})
+	}
+}
+
+func TestAdmitClamp(t *testing.T) {
+	s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+		a.Policy = &policy.Policy{
+			Enforcement: policy.Clamp,
+			Routes:      &policy.RouteRule{MinGroupWait: model.Duration(30 * time.Second)},
+		}
+	})
+	defer s.close()
+	s.c.Create(receiverConfigMap("team-a", "receiver", "team-a"))
+	s.c.Create(configMap("team-a", "route", map[string]string{
+		"route.yaml": "# paging of team a\n- receiver: team-a\n  group_wait: 5s # fast\n",
+	}, "alertmanager.net/route", "true"))
+
+	route := s.readFile("routes/team-a-route-route.yaml")
+	for _, want := range []string{"# paging of team a\n", "group_wait: 30s # fast\n", "continue: true"} {
+		if !strings.Contains(route, want) {
+			t.Errorf("route does not contain %q:\n%s", want, route)
+		}
+	}
+	if len(s.c.Rejections()) != 0 {
+		t.Errorf("rejections = %v, want none", s.c.Rejections())
+	}
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckBackupConfigs(t *testing.T) {
+	rule := "- source_match:\n    severity: critical\n  target_match:\n    severity: warning\n  equal: [alertname]\n"
+	cases := []struct {
+		name   string
+		active map[string]string
+		backup map[string]string
+		// files in inhibit-rules and backup-inhibit-rules after the next change
+		inhibitRules []string
+		backups      []string
+	}{
+		{
+			name:         "backup is retried without active inhibit rules",
+			backup:       map[string]string{"team-a-rule-inhibit_rule.yaml": rule},
+			inhibitRules: []string{"team-a-rule-inhibit_rule.yaml"},
+			backups:      []string{},
+		},
+		{
+			name:         "backup is retried next to active inhibit rules",
+			active:       map[string]string{"team-a-active-inhibit_rule.yaml": rule},
+			backup:       map[string]string{"team-b-rule-inhibit_rule.yaml": rule},
+			inhibitRules: []string{"team-a-active-inhibit_rule.yaml", "team-b-rule-inhibit_rule.yaml"},
+			backups:      []string{},
+		},
+		{
+			name:         "invalid backup stays a backup",
+			active:       map[string]string{"team-a-active-inhibit_rule.yaml": rule},
+			backup:       map[string]string{"team-b-rule-inhibit_rule.yaml": "- source_match: critical\n"},
+			inhibitRules: []string{"team-a-active-inhibit_rule.yaml"},
+			backups:      []string{"team-b-rule-inhibit_rule.yaml"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetup(t)
+			defer s.close()
+			// the directory of the active inhibit rules is kept after the last one is removed
+			if err := os.MkdirAll(filepath.Join(s.c.a.ConfigPath, "inhibit-rules"), 0766); err != nil {
+				t.Fatal(err)
+			}
+			for file, content := range tc.active {
+				s.writeFile("config/inhibit-rules/"+file, content)
+			}
+			for file, content := range tc.backup {
+				s.writeFile("config/backup-inhibit-rules/"+file, content)
+			}
+			s.c.Create(receiverConfigMap("team-c", "receiver", "c"))
+
+			if got := s.files("inhibit-rules"); !equalStrings(got, tc.inhibitRules) {
+				t.Errorf("inhibit rules = %v, want %v", got, tc.inhibitRules)
+			}
+			if got := s.files("backup-inhibit-rules"); !equalStrings(got, tc.backups) {
+				t.Errorf("backup inhibit rules = %v, want %v", got, tc.backups)
+			}
+			if len(tc.backups) == 0 && !strings.Contains(s.config(), "target_match") {
+				t.Errorf("config does not contain the retried inhibit rule:\n%s", s.config())
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	"github.com/go-kit/kit/log/level"
+	alcf "github.com/prometheus/alertmanager/config"
+	v1 "k8s.io/api/core/v1"
+)
+
+var (
+	bundleConst = "bundle"
+	bundleDir   = "bundles"
+	// keys of a bundle configmap and the config type of their content
+	bundleKeys = map[string]string{
+		"routes.yaml":        routeConst,
+		"receivers.yaml":     receiverConst,
+		"inhibit_rules.yaml": inhibitRuleConst,
+	}
+)
+
+// part is a single fragment file taken from a key of a configmap
+type part struct {
+	configType string
+	key        string
+	filename   string
+	content    string
+}
+
+// split a configmap into its fragment files, a bundle contributes one file per known key
+func parts(configmapObj *v1.ConfigMap, configType string) []part {
+	if configType == "" {
+		return nil
+	}
+	keys := make([]string, 0, len(configmapObj.Data))
+	for k := range configmapObj.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var result []part
+	for _, k := range keys {
+		p := part{
+			configType: configType,
+			key:        k,
+			filename:   configmapObj.Namespace + "-" + configmapObj.Name + "-" + k,
+			content:    configmapObj.Data[k],
+		}
+		switch configType {
+		case configConst:
+			p.filename = k
+		case bundleConst:
+			t, ok := bundleKeys[k]
+			if !ok {
+				continue
+			}
+			p.configType = t
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// keys of a bundle configmap which do not carry a fragment type
+func unknownBundleKeys(configmapObj *v1.ConfigMap) []string {
+	var unknown []string
+	for k := range configmapObj.Data {
+		if _, ok := bundleKeys[k]; !ok {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// go through backup bundles to check if any of them can be used now, a bundle is only used as a whole
+func (c *Controller) checkBackupBundles() {
+	bundlePaths, err := filepath.Glob(c.a.ConfigPath + "/backup-" + bundleDir + "/*")
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to read backup bundles", "err", err.Error())
+	}
+
+	routes := c.readConfigs("routes")
+	receivers := c.readConfigs("receivers")
+	inhibitRules := c.readConfigs("inhibit-rules")
+
+	t, configTemplate, err := c.parseTemplate()
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to parse template: "+c.a.ConfigTemplate, "err", err.Error())
+		return
+	}
+
+	for _, bundlePath := range bundlePaths {
+		files, err := filepath.Glob(bundlePath + "/*")
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to read bundle: "+bundlePath, "err", err.Error())
+			continue
+		}
+
+		var alertmanagerConfig alertmanager.Config
+		newRoutes, newReceivers, newInhibitRules := routes, receivers, inhibitRules
+		for _, file := range files {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				//nolint:errcheck
+				level.Error(c.logger).Log("msg", "Failed to read bundle file: "+file, "err", err.Error())
+			}
+			switch bundleKeys[filepath.Base(file)] {
+			case routeConst:
+				newRoutes = newRoutes + string(content) + "\n"
+			case receiverConst:
+				newReceivers = newReceivers + string(content) + "\n"
+			case inhibitRuleConst:
+				newInhibitRules = newInhibitRules + string(content) + "\n"
+			}
+		}
+		alertmanagerConfig.Routes = strings.Replace(newRoutes, "\n", "\n  ", -1)
+		alertmanagerConfig.Receivers = newReceivers
+		alertmanagerConfig.InhibitRules = newInhibitRules
+
+		tpl, err := c.executeTemplate(t, configTemplate, alertmanagerConfig)
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to template alertmanager config", "err", err.Error())
+			continue
+		}
+		_, configErr := alcf.Load(string(tpl))
+		if configErr != nil {
+			//nolint:errcheck
+			level.Debug(c.logger).Log("msg", "Bundle is unavailable", "bundle", bundlePath, "err", configErr.Error())
+			continue
+		}
+
+		for _, file := range files {
+			configType, ok := bundleKeys[filepath.Base(file)]
+			if !ok {
+				continue
+			}
+			path := c.a.ConfigPath + "/" + configDir(configType) + "/"
+			if _, err = os.Stat(path); os.IsNotExist(err) {
+				err = os.MkdirAll(path, 0766)
+				if err != nil {
+					//nolint:errcheck
+					level.Error(c.logger).Log("msg", "Failed to create directory", "err", err.Error())
+				}
+			}
+			c.copyFile(file, path+filepath.Base(bundlePath)+"-"+filepath.Base(file))
+			c.setFragmentQuotaInactive(configDir(configType), filepath.Base(bundlePath)+"-"+filepath.Base(file), false)
+		}
+		err = os.RemoveAll(bundlePath)
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to delete bundle: "+bundlePath, "err", err.Error())
+		}
+		//nolint:errcheck
+		level.Debug(c.logger).Log("msg", "Bundle is available", "bundle", bundlePath)
+		c.checkBackupConfigs()
+		break
+	}
+}
+
+// the configmap a fragment was taken from, if it is part of a bundle
+func (c *Controller) bundleOf(dir string, filename string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s, ok := c.sources[dir+"/"+filename]
+	if !ok || !s.bundle {
+		return "", false
+	}
+	return s.namespace + "/" + s.name, true
+}
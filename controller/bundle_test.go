@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestParts(t *testing.T) {
+	cases := []struct {
+		name       string
+		data       map[string]string
+		configType string
+		// config type and filename of every part
+		want []string
+	}{
+		{"no config type", map[string]string{"r.yaml": "- name: r\n"}, "", nil},
+		{"one part per key", map[string]string{"b.yaml": "", "a.yaml": ""}, receiverConst, []string{
+			"receiver team-a-cm-a.yaml", "receiver team-a-cm-b.yaml",
+		}},
+		{"config keeps the key as filename", map[string]string{"alertmanager.tmpl": ""}, configConst, []string{
+			"config alertmanager.tmpl",
+		}},
+		{"bundle keys are split by type", map[string]string{
+			"routes.yaml":        "",
+			"receivers.yaml":     "",
+			"inhibit_rules.yaml": "",
+			"README.md":          "",
+		}, bundleConst, []string{
+			"inhibit rule team-a-cm-inhibit_rules.yaml", "receiver team-a-cm-receivers.yaml", "route team-a-cm-routes.yaml",
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, p := range parts(configMap("team-a", "cm", tc.data), tc.configType) {
+				got = append(got, p.configType+" "+p.filename)
+			}
+			if !equalStrings(got, tc.want) {
+				t.Errorf("parts = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// a bundle with a route to receiver and the receiver if it is not empty
+func bundleConfigMap(namespace string, name string, route string, receiver string) *v1.ConfigMap {
+	data := map[string]string{"routes.yaml": "- receiver: " + route + "\n  match:\n    team: " + name + "\n"}
+	if receiver != "" {
+		data["receivers.yaml"] = "- name: " + receiver + "\n  webhook_configs:\n  - url: http://" + receiver + "\n"
+	}
+	return configMap(namespace, name, data, "alertmanager.net/bundle", "true")
+}
+
+func TestBundle(t *testing.T) {
+	cases := []struct {
+		name string
+		run  func(s *testSetup)
+		// files in routes, receivers and backup-bundles after the run
+		routes    []string
+		receivers []string
+		backup    []string
+	}{
+		{
+			name: "bundle is split into its fragments",
+			run: func(s *testSetup) {
+				s.c.Create(bundleConfigMap("team-a", "b", "b", "b"))
+			},
+			routes:    []string{"team-a-b-routes.yaml"},
+			receivers: []string{"team-a-b-receivers.yaml"},
+			backup:    []string{},
+		},
+		{
+			name: "bundle with a missing receiver is kept back as a whole",
+			run: func(s *testSetup) {
+				s.c.Create(bundleConfigMap("team-a", "b", "other", "b"))
+			},
+			routes:    []string{},
+			receivers: []string{},
+			backup:    []string{"team-a-b"},
+		},
+		{
+			name: "bundle is applied when the missing receiver is created",
+			run: func(s *testSetup) {
+				s.c.Create(bundleConfigMap("team-a", "b", "other", "b"))
+				s.c.Create(receiverConfigMap("team-b", "other", "other"))
+			},
+			routes:    []string{"team-a-b-routes.yaml"},
+			receivers: []string{"team-a-b-receivers.yaml", "team-b-other-receiver.yaml"},
+			backup:    []string{},
+		},
+		{
+			name: "deleted bundle removes all fragments",
+			run: func(s *testSetup) {
+				s.c.Create(bundleConfigMap("team-a", "b", "b", "b"))
+				s.c.Delete(bundleConfigMap("team-a", "b", "b", "b"))
+			},
+			routes:    []string{},
+			receivers: []string{},
+			backup:    []string{},
+		},
+		{
+			name: "deleted backup bundle is forgotten",
+			run: func(s *testSetup) {
+				s.c.Create(bundleConfigMap("team-a", "b", "other", "b"))
+				s.c.Delete(bundleConfigMap("team-a", "b", "other", "b"))
+				s.c.Create(receiverConfigMap("team-b", "other", "other"))
+			},
+			routes:    []string{},
+			receivers: []string{"team-b-other-receiver.yaml"},
+			backup:    []string{},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetup(t)
+			defer s.close()
+			tc.run(s)
+
+			if got := s.files("routes"); !equalStrings(got, tc.routes) {
+				t.Errorf("routes = %v, want %v", got, tc.routes)
+			}
+			if got := s.files("receivers"); !equalStrings(got, tc.receivers) {
+				t.Errorf("receivers = %v, want %v", got, tc.receivers)
+			}
+			if got := s.files("backup-bundles"); !equalStrings(got, tc.backup) {
+				t.Errorf("backup bundles = %v, want %v", got, tc.backup)
+			}
+			if len(tc.routes) == 0 && strings.Contains(s.config(), "team: b") {
+				t.Errorf("config contains the route of the bundle:\n%s", s.config())
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	"github.com/dbsystel/alertmanager-config-controller/policy"
+)
+
+func TestAddContinueIfNotExist(t *testing.T) {
+	p := &policy.Policy{Routes: &policy.RouteRule{StopNamespaces: []string{"monitoring"}}}
+	cases := []struct {
+		name      string
+		policy    *policy.Policy
+		namespace string
+		routes    string
+		want      string
+	}{
+		{
+			name:      "continue is added",
+			namespace: "team-a",
+			routes:    "- receiver: a\n",
+			want:      "- receiver: a\n  continue: true\n",
+		},
+		{
+			name:      "continue: true is kept",
+			namespace: "team-a",
+			routes:    "- receiver: a\n  continue: true\n",
+			want:      "- receiver: a\n  continue: true\n",
+		},
+		{
+			name:      "continue: false is replaced without policy",
+			namespace: "monitoring",
+			routes:    "- receiver: blackhole\n  continue: false\n",
+			want:      "- receiver: blackhole\n  continue: true\n",
+		},
+		{
+			name:      "continue: false is replaced in other namespaces",
+			policy:    p,
+			namespace: "team-a",
+			routes:    "- receiver: blackhole\n  continue: false\n",
+			want:      "- receiver: blackhole\n  continue: true\n",
+		},
+		{
+			name:      "continue: false is kept in stop namespaces",
+			policy:    p,
+			namespace: "monitoring",
+			routes:    "- receiver: blackhole\n  continue: false\n",
+			want:      "- receiver: blackhole\n  continue: false\n",
+		},
+		{
+			name:      "continue is added in stop namespaces",
+			policy:    p,
+			namespace: "monitoring",
+			routes:    "- receiver: a\n",
+			want:      "- receiver: a\n  continue: true\n",
+		},
+		{
+			name:      "nested routes are not changed",
+			namespace: "team-a",
+			routes:    "- receiver: a\n  routes:\n  - receiver: b\n    continue: false\n",
+			want:      "- receiver: a\n  routes:\n    - receiver: b\n      continue: false\n  continue: true\n",
+		},
+		{
+			name:      "comments, order and style are kept",
+			namespace: "team-a",
+			routes:    "# paging\n- receiver: a # team a\n  match: {severity: critical}\n  group_by: [alertname]\n",
+			want:      "# paging\n- receiver: a # team a\n  match: {severity: critical}\n  group_by: [alertname]\n  continue: true\n",
+		},
+		{
+			name:      "alias of a route is copied",
+			namespace: "team-a",
+			routes:    "- &paging\n  receiver: a\n  continue: false\n- *paging\n",
+			want:      "- &paging\n  receiver: a\n  continue: true\n- receiver: a\n  continue: true\n",
+		},
+		{
+			name:      "alias of continue: false is replaced",
+			namespace: "team-a",
+			routes:    "- receiver: a\n  routes:\n    - receiver: b\n      continue: &stop false\n  continue: *stop\n",
+			want:      "- receiver: a\n  routes:\n    - receiver: b\n      continue: &stop false\n  continue: true\n",
+		},
+		{
+			name:      "alias of continue: false is kept in stop namespaces",
+			policy:    p,
+			namespace: "monitoring",
+			routes:    "- receiver: a\n  routes:\n    - receiver: b\n      continue: &stop false\n  continue: *stop\n",
+			want:      "- receiver: a\n  routes:\n    - receiver: b\n      continue: &stop false\n  continue: *stop\n",
+		},
+		{
+			name:      "empty fragment",
+			namespace: "team-a",
+			routes:    "",
+			want:      "[]\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+				a.Policy = tc.policy
+			})
+			defer s.close()
+
+			got, err := s.c.addContinueIfNotExist(routeConfigMap(tc.namespace, "routes", "a"), tc.routes)
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("routes = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStopRouting(t *testing.T) {
+	s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+		a.Policy = &policy.Policy{Routes: &policy.RouteRule{StopNamespaces: []string{"monitoring"}}}
+	})
+	defer s.close()
+	s.c.Create(receiverConfigMap("monitoring", "blackhole", "blackhole"))
+	s.c.Create(configMap("monitoring", "noisy", map[string]string{
+		"route.yaml": "- receiver: blackhole\n  match:\n    noisy: \"true\"\n  continue: false\n",
+	}, "alertmanager.net/route", "true"))
+	s.c.Create(receiverConfigMap("team-a", "receiver", "team-a"))
+	s.c.Create(configMap("team-a", "swallow", map[string]string{
+		"route.yaml": "- receiver: team-a\n  continue: false\n",
+	}, "alertmanager.net/route", "true"))
+
+	if got := s.readFile("routes/monitoring-noisy-route.yaml"); !strings.Contains(got, "continue: false") {
+		t.Errorf("route of monitoring does not stop:\n%s", got)
+	}
+	if got := s.readFile("routes/team-a-swallow-route.yaml"); !strings.Contains(got, "continue: true") {
+		t.Errorf("route of team-a stops:\n%s", got)
+	}
+	if config := s.config(); strings.Count(config, "continue: false") != 1 {
+		t.Errorf("config does not contain exactly one continue: false:\n%s", config)
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	cases := []struct {
+		name   string
+		routes string
+		// error contains, empty if the routes are valid
+		err string
+	}{
+		{"routes", "- receiver: a\n- receiver: b\n", ""},
+		{"empty", "", ""},
+		{"alias of a route", "- &a\n  receiver: a\n- *a\n", ""},
+		{"invalid yaml", "- receiver: a\n receiver: b\n", "yaml:"},
+		{"no list", "receiver: a\n", "line 1: routes must be a list"},
+		{"no mapping", "- receiver: a\n- b\n", "line 2: a route must be a mapping"},
+		{"alias of no mapping", "- &b b\n- *b\n", "line 1: a route must be a mapping"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseRoutes(tc.routes)
+			if tc.err == "" && err != nil {
+				t.Errorf("parseRoutes() = %v, want valid", err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("parseRoutes() = %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestInvalidRoutesAreQuarantined(t *testing.T) {
+	s := newTestSetup(t)
+	defer s.close()
+	s.c.Create(receiverConfigMap("team-a", "receiver", "a"))
+	s.c.Create(configMap("team-a", "route", map[string]string{
+		"route.yaml": "receiver: a\n",
+	}, "alertmanager.net/route", "true"))
+
+	if got := s.files("routes"); len(got) != 0 {
+		t.Errorf("routes = %v, want none", got)
+	}
+	if got := s.readFile("quarantine-routes/team-a-route-route.yaml"); got != "receiver: a\n" {
+		t.Errorf("quarantined route = %q, want the fragment as it is", got)
+	}
+}
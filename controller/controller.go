@@ -2,19 +2,21 @@ package controller
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"text/template"
+	"sync"
 
 	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	"github.com/dbsystel/alertmanager-config-controller/history"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	alcf "github.com/prometheus/alertmanager/config"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 	v1 "k8s.io/api/core/v1"
 )
 
@@ -27,8 +29,19 @@ var (
 
 // Controller wrapper for alertmanager
 type Controller struct {
-	logger log.Logger
-	a      alertmanager.APIClient
+	logger     log.Logger
+	a          alertmanager.APIClient
+	history    *history.Store
+	mu         sync.RWMutex
+	sources    map[string]source
+	rejections map[string]Rejection
+	conflicts  []Conflict
+	// receivers of the default routes of namespaces
+	teamReceivers map[string]string
+	// configmaps are replayed by Reconcile, configs are built but neither published nor reloaded
+	replaying bool
+	// configmaps whose fragments count against the quotas, by namespace/name, guarded by mu
+	quotaEntries map[string]*quotaEntry
 }
 
 // New creates new Controller instance
@@ -36,57 +49,94 @@ func New(a alertmanager.APIClient, logger log.Logger) *Controller {
 	controller := &Controller{}
 	controller.logger = logger
 	controller.a = a
+	controller.history = history.New(a.ConfigPath+"/history", a.HistoryLimit)
+	controller.sources = make(map[string]source)
+	controller.rejections = make(map[string]Rejection)
+	controller.teamReceivers = make(map[string]string)
+	controller.quotaEntries = make(map[string]*quotaEntry)
 	return controller
 }
 
 // Create is called when a configmap is created
 func (c *Controller) Create(obj interface{}) {
 	configmapObj := obj.(*v1.ConfigMap)
-	id := configmapObj.Annotations["alertmanager.net/id"]
+	if _, ok := receiverTemplateName(configmapObj); ok {
+		c.createReceiverTemplate(configmapObj)
+		return
+	}
+	configmapObj, err := c.receiverInstance(configmapObj)
+	if err != nil {
+		c.reject(obj.(*v1.ConfigMap), receiverInstanceConst, "template", err.Error())
+		return
+	}
 	route := configmapObj.Annotations["alertmanager.net/route"]
 	receiver := configmapObj.Annotations["alertmanager.net/receiver"]
 	inhibitRule := configmapObj.Annotations["alertmanager.net/inhibit_rule"]
+	bundle := configmapObj.Annotations["alertmanager.net/bundle"]
 	config := configmapObj.Annotations["alertmanager.net/config"]
-	key := configmapObj.Annotations["alertmanager.net/key"]
+	overlay := configmapObj.Annotations["alertmanager.net/overlay"]
 	isAlertmanagerRoute, _ := strconv.ParseBool(route)
 	isAlertmanagerReceiver, _ := strconv.ParseBool(receiver)
 	isAlertmanagerInhibitRule, _ := strconv.ParseBool(inhibitRule)
+	isAlertmanagerBundle, _ := strconv.ParseBool(bundle)
 	isAlertmanagerConfig, _ := strconv.ParseBool(config)
-	alertmanagerID, _ := strconv.Atoi(id)
+	isAlertmanagerOverlay, _ := strconv.ParseBool(overlay)
 
-	if alertmanagerID == c.a.ID &&
-		((isAlertmanagerConfig && key == c.a.Key) ||
+	if c.matchesID(configmapObj) &&
+		((isAlertmanagerConfig && c.authorized(configmapObj)) ||
+			(isAlertmanagerOverlay && c.authorized(configmapObj)) ||
 			isAlertmanagerRoute ||
 			isAlertmanagerReceiver ||
-			isAlertmanagerInhibitRule) {
+			isAlertmanagerInhibitRule ||
+			isAlertmanagerBundle) {
 
 		configType := c.findConfigType(isAlertmanagerRoute,
 			isAlertmanagerReceiver,
 			isAlertmanagerInhibitRule,
-			isAlertmanagerConfig)
+			isAlertmanagerBundle,
+			isAlertmanagerConfig,
+			isAlertmanagerOverlay)
+
+		// the policy and the quotas apply to the content with the variables replaced
+		received := configmapObj
+		configmapObj, unresolved := c.substituteVariables(configmapObj, configType)
+		configmapObj, admitted := c.admit(configmapObj, configType)
+		if !admitted {
+			return
+		}
+		if unresolved != nil {
+			c.quarantineConfigMap(configmapObj, configType, "variable", "unresolved variables: "+unresolved.Error())
+			return
+		}
+		if configType != configConst && configType != overlayConst {
+			c.trackQuota(received, configmapObj, configType)
+			if err := c.checkQuota(configmapObj, configType); err != nil {
+				c.quarantineQuota(configmapObj, configType, err)
+				return
+			}
+		}
+		if err := c.checkRoutes(configmapObj, configType); err != nil {
+			c.quarantineConfigMap(configmapObj, configType, "invalid", "invalid route: "+err.Error())
+			return
+		}
 
-		c.createConfig(configmapObj, configType)
+		if _, err := c.createConfig(configmapObj, configType); err != nil {
+			return
+		}
 
+		// backups which become active count against the quotas as well
 		c.checkBackupConfigs()
+		c.enforceQuotas()
 
 		err := c.buildConfig()
 		if err == nil {
-			_, err = c.a.Reload()
-			if err != nil {
-				//nolint:errcheck
-				level.Error(c.logger).Log(
-					"msg", "Failed to reload alertmanager.yml",
-					"err", err.Error(),
-					"namespace", configmapObj.Namespace,
-					"name", configmapObj.Name,
-				)
-			} else {
-				//nolint:errcheck
-				level.Info(c.logger).Log("msg", "Succeeded: Reloaded Alertmanager")
-			}
+			c.reload(configmapObj)
+		} else if configType == overlayConst {
+			c.deleteConfig(configmapObj)
+			c.quarantineConfigMap(configmapObj, configType, "invalid", "invalid config: "+err.Error())
 		} else if configType != configConst {
-			if configType == routeConst || configType == receiverConst || configType == inhibitRuleConst {
-				c.createBackfile(configmapObj, configType)
+			if configType == routeConst || configType == receiverConst || configType == inhibitRuleConst || configType == bundleConst {
+				c.createBackfile(configmapObj, configType, err.Error())
 			}
 			c.deleteConfig(configmapObj)
 		}
@@ -96,27 +146,33 @@ func (c *Controller) Create(obj interface{}) {
 	}
 }
 
-func (c *Controller) findConfigType(isRoute, isReceiver, isInhibitRule, isConfig bool) string {
+func (c *Controller) findConfigType(isRoute, isReceiver, isInhibitRule, isBundle, isConfig, isOverlay bool) string {
 	check := strconv.FormatBool(isRoute) +
 		"-" + strconv.FormatBool(isReceiver) +
 		"-" + strconv.FormatBool(isInhibitRule) +
-		"-" + strconv.FormatBool(isConfig)
+		"-" + strconv.FormatBool(isBundle) +
+		"-" + strconv.FormatBool(isConfig) +
+		"-" + strconv.FormatBool(isOverlay)
 	switch check {
-	case "true-false-false-false":
+	case "true-false-false-false-false-false":
 		return routeConst
-	case "false-true-false-false":
+	case "false-true-false-false-false-false":
 		return receiverConst
-	case "false-false-true-false":
+	case "false-false-true-false-false-false":
 		return inhibitRuleConst
-	case "false-false-false-true":
+	case "false-false-false-true-false-false":
+		return bundleConst
+	case "false-false-false-false-true-false":
 		return "config"
+	case "false-false-false-false-false-true":
+		return overlayConst
 	default:
 		return ""
 	}
 }
 
 func (c *Controller) checkBackupConfigs() {
-	files, _ := ioutil.ReadDir(c.a.ConfigPath + "/inhibit-rules")
+	files, _ := ioutil.ReadDir(c.a.ConfigPath + "/backup-inhibit-rules")
 	if len(files) > 0 {
 		//nolint:errcheck
 		level.Debug(c.logger).Log("msg", "Checking backup inhibit rules for new receiver...")
@@ -134,21 +190,42 @@ func (c *Controller) checkBackupConfigs() {
 		level.Debug(c.logger).Log("msg", "Checking backup receivers...")
 		c.checkBackupReceivers()
 	}
+	files, _ = ioutil.ReadDir(c.a.ConfigPath + "/backup-" + bundleDir)
+	if len(files) > 0 {
+		//nolint:errcheck
+		level.Debug(c.logger).Log("msg", "Checking backup bundles...")
+		c.checkBackupBundles()
+	}
 }
 
 // Delete is called when a configmap is deleted
 func (c *Controller) Delete(obj interface{}) {
 	configmapObj := obj.(*v1.ConfigMap)
-	id := configmapObj.Annotations["alertmanager.net/id"]
+	if _, ok := receiverTemplateName(configmapObj); ok {
+		if c.matchesID(configmapObj) {
+			c.deleteReceiverTemplate(configmapObj)
+		}
+		return
+	}
+	if _, ok := receiverInstanceTemplate(configmapObj); ok && c.matchesID(configmapObj) {
+		c.deleteReceiverInstance(configmapObj)
+		configmapObj = receiverInstanceReceiver(configmapObj, "")
+	}
 	route := configmapObj.Annotations["alertmanager.net/route"]
 	receiver := configmapObj.Annotations["alertmanager.net/receiver"]
 	inhibitRule := configmapObj.Annotations["alertmanager.net/inhibit_rule"]
+	bundle := configmapObj.Annotations["alertmanager.net/bundle"]
+	overlay := configmapObj.Annotations["alertmanager.net/overlay"]
 	isAlertmanagerRoute, _ := strconv.ParseBool(route)
 	isAlertmanagerReceiver, _ := strconv.ParseBool(receiver)
 	isAlertmanagerInhibitRule, _ := strconv.ParseBool(inhibitRule)
-	alertmanagerID, _ := strconv.Atoi(id)
+	isAlertmanagerBundle, _ := strconv.ParseBool(bundle)
+	isAlertmanagerOverlay, _ := strconv.ParseBool(overlay)
+
+	c.forgetRejection(configmapObj)
 
-	if alertmanagerID == c.a.ID && (isAlertmanagerReceiver || isAlertmanagerRoute || isAlertmanagerInhibitRule) {
+	if c.matchesID(configmapObj) && (isAlertmanagerReceiver || isAlertmanagerRoute || isAlertmanagerInhibitRule || isAlertmanagerBundle || isAlertmanagerOverlay) {
+		c.untrackQuota(configmapObj)
 		c.deleteConfig(configmapObj)
 		if isAlertmanagerRoute {
 			c.deleteBackupFile(configmapObj, routeConst)
@@ -159,25 +236,20 @@ func (c *Controller) Delete(obj interface{}) {
 		if isAlertmanagerInhibitRule {
 			c.deleteBackupFile(configmapObj, inhibitRuleConst)
 		}
+		if isAlertmanagerBundle {
+			c.deleteBackupFile(configmapObj, bundleConst)
+		}
+		if isAlertmanagerOverlay {
+			c.deleteBackupFile(configmapObj, overlayConst)
+		}
 
 		c.checkBackupConfigs()
 
 		err := c.buildConfig()
 		if err == nil {
-			_, err = c.a.Reload()
-			if err != nil {
-				//nolint:errcheck
-				level.Error(c.logger).Log(
-					"msg", "Failed to reload alertmanager.yml",
-					"err", err.Error(),
-					"namespace", configmapObj.Namespace,
-					"name", configmapObj.Name,
-				)
-			} else {
-				//nolint:errcheck
-				level.Info(c.logger).Log("msg", "Succeeded: Reloaded Alertmanager")
-			}
+			c.reload(configmapObj)
 		}
+		c.retryQuotas()
 
 	} else {
 		//nolint:errcheck
@@ -190,186 +262,398 @@ func (c *Controller) Delete(obj interface{}) {
 func (c *Controller) Update(oldobj, newobj interface{}) {
 	newConfigmapObj := newobj.(*v1.ConfigMap)
 	oldConfigmapObj := oldobj.(*v1.ConfigMap)
-	newID := newConfigmapObj.Annotations["alertmanager.net/id"]
-	oldID := oldConfigmapObj.Annotations["alertmanager.net/id"]
+	if noDifference(oldConfigmapObj, newConfigmapObj) {
+		//nolint:errcheck
+		level.Debug(c.logger).Log("msg", "Skipping automatically updated configmap:"+newConfigmapObj.Name)
+		return
+	}
+	_, isOldReceiverTemplate := receiverTemplateName(oldConfigmapObj)
+	_, isReceiverTemplate := receiverTemplateName(newConfigmapObj)
+	if isOldReceiverTemplate || isReceiverTemplate {
+		c.Delete(oldConfigmapObj)
+		c.Create(newConfigmapObj)
+		return
+	}
+	_, isOldReceiverInstance := receiverInstanceTemplate(oldConfigmapObj)
+	_, isReceiverInstance := receiverInstanceTemplate(newConfigmapObj)
+	if isOldReceiverInstance {
+		c.deleteReceiverInstance(oldConfigmapObj)
+		oldConfigmapObj = receiverInstanceReceiver(oldConfigmapObj, "")
+	}
+	if isReceiverInstance {
+		receiver, err := c.receiverInstance(newConfigmapObj)
+		if err != nil {
+			// an instance which can not be expanded removes the receiver of its last expansion
+			c.Delete(oldConfigmapObj)
+			c.reject(newConfigmapObj, receiverInstanceConst, "template", err.Error())
+			return
+		}
+		newConfigmapObj = receiver
+	}
 	route := newConfigmapObj.Annotations["alertmanager.net/route"]
 	oldRoute := oldConfigmapObj.Annotations["alertmanager.net/route"]
 	receiver := newConfigmapObj.Annotations["alertmanager.net/receiver"]
 	oldReceiver := oldConfigmapObj.Annotations["alertmanager.net/receiver"]
 	inhibitRule := newConfigmapObj.Annotations["alertmanager.net/inhibit_rule"]
 	oldInhibitRule := oldConfigmapObj.Annotations["alertmanager.net/inhibit_rule"]
+	bundle := newConfigmapObj.Annotations["alertmanager.net/bundle"]
+	oldBundle := oldConfigmapObj.Annotations["alertmanager.net/bundle"]
 	config := newConfigmapObj.Annotations["alertmanager.net/config"]
-	key := newConfigmapObj.Annotations["alertmanager.net/key"]
+	overlay := newConfigmapObj.Annotations["alertmanager.net/overlay"]
+	oldOverlay := oldConfigmapObj.Annotations["alertmanager.net/overlay"]
 	isAlertmanagerRoute, _ := strconv.ParseBool(route)
 	isOldAlertmanagerRoute, _ := strconv.ParseBool(oldRoute)
 	isAlertmanagerReceiver, _ := strconv.ParseBool(receiver)
 	isOldAlertmanagerReceiver, _ := strconv.ParseBool(oldReceiver)
 	isAlertmanagerInhibitRule, _ := strconv.ParseBool(inhibitRule)
 	isOldAlertmanagerInhibitRule, _ := strconv.ParseBool(oldInhibitRule)
+	isAlertmanagerBundle, _ := strconv.ParseBool(bundle)
+	isOldAlertmanagerBundle, _ := strconv.ParseBool(oldBundle)
 	isAlertmanagerConfig, _ := strconv.ParseBool(config)
-	newAlertmanagerID, _ := strconv.Atoi(newID)
-	oldAlertmanagerID, _ := strconv.Atoi(oldID)
+	isAlertmanagerOverlay, _ := strconv.ParseBool(overlay)
+	isOldAlertmanagerOverlay, _ := strconv.ParseBool(oldOverlay)
+	isNewTarget := c.matchesID(newConfigmapObj)
+	isOldTarget := c.matchesID(oldConfigmapObj)
 
-	if newAlertmanagerID == oldAlertmanagerID && noDifference(oldConfigmapObj, newConfigmapObj) {
-		//nolint:errcheck
-		level.Debug(c.logger).Log("msg", "Skipping automatically updated configmap:"+newConfigmapObj.Name)
-		return
-	}
-	if (oldAlertmanagerID == c.a.ID || newAlertmanagerID == c.a.ID) &&
+	if (isOldTarget || isNewTarget) &&
 		(isOldAlertmanagerRoute ||
 			isAlertmanagerRoute ||
 			isOldAlertmanagerReceiver ||
 			isAlertmanagerReceiver ||
 			isOldAlertmanagerInhibitRule ||
 			isAlertmanagerConfig ||
-			isAlertmanagerInhibitRule) {
-
-		if oldAlertmanagerID == c.a.ID {
+			isAlertmanagerInhibitRule ||
+			isOldAlertmanagerBundle ||
+			isAlertmanagerBundle ||
+			isOldAlertmanagerOverlay ||
+			isAlertmanagerOverlay) {
+
+		// the active fragments of the old version stay in place until the new version is committed
+		oldConfigType := c.findConfigType(isOldAlertmanagerRoute,
+			isOldAlertmanagerReceiver,
+			isOldAlertmanagerInhibitRule,
+			isOldAlertmanagerBundle,
+			false,
+			isOldAlertmanagerOverlay)
+		if isOldTarget {
+			c.untrackQuota(oldConfigmapObj)
 			if isOldAlertmanagerReceiver {
-				c.deleteConfig(oldConfigmapObj)
 				c.deleteBackupFile(oldConfigmapObj, receiverConst)
 			}
 			if isOldAlertmanagerRoute {
-				c.deleteConfig(oldConfigmapObj)
 				c.deleteBackupFile(oldConfigmapObj, routeConst)
 			}
 			if isOldAlertmanagerInhibitRule {
-				c.deleteConfig(oldConfigmapObj)
 				c.deleteBackupFile(oldConfigmapObj, inhibitRuleConst)
 			}
+			if isOldAlertmanagerBundle {
+				c.deleteBackupFile(oldConfigmapObj, bundleConst)
+			}
+			if isOldAlertmanagerOverlay {
+				c.deleteBackupFile(oldConfigmapObj, overlayConst)
+			}
 		}
 
 		newConfigType := c.findConfigType(isAlertmanagerRoute,
 			isAlertmanagerReceiver,
 			isAlertmanagerInhibitRule,
-			isAlertmanagerConfig)
-
-		if newAlertmanagerID == c.a.ID {
+			isAlertmanagerBundle,
+			isAlertmanagerConfig,
+			isAlertmanagerOverlay)
+
+		admitted := false
+		// the new version was committed or failed to be written
+		committed, failed := false, false
+		// content of the files replaced by the new version
+		var snapshot fileSnapshot
+		// the policy and the quotas apply to the content with the variables replaced
+		received := newConfigmapObj
+		if isNewTarget {
+			var unresolved error
+			newConfigmapObj, unresolved = c.substituteVariables(newConfigmapObj, newConfigType)
+			newConfigmapObj, admitted = c.admit(newConfigmapObj, newConfigType)
+			if admitted && unresolved != nil {
+				c.quarantineConfigMap(newConfigmapObj, newConfigType, "variable", "unresolved variables: "+unresolved.Error())
+				admitted = false
+			}
+		}
+		if admitted && newConfigType != configConst && newConfigType != overlayConst {
+			c.trackQuota(received, newConfigmapObj, newConfigType)
+			if err := c.checkQuota(newConfigmapObj, newConfigType); err != nil {
+				c.quarantineQuota(newConfigmapObj, newConfigType, err)
+				admitted = false
+			}
+		}
+		if admitted {
+			if err := c.checkRoutes(newConfigmapObj, newConfigType); err != nil {
+				c.quarantineConfigMap(newConfigmapObj, newConfigType, "invalid", "invalid route: "+err.Error())
+				admitted = false
+			}
+		}
+		if admitted {
 			if (isAlertmanagerReceiver ||
 				isAlertmanagerRoute ||
-				isAlertmanagerInhibitRule) ||
-				(isAlertmanagerConfig && key == c.a.Key) {
-
-				c.createConfig(newConfigmapObj, newConfigType)
+				isAlertmanagerInhibitRule ||
+				isAlertmanagerBundle) ||
+				(isAlertmanagerConfig && c.authorized(received)) ||
+				(isAlertmanagerOverlay && c.authorized(received)) {
+
+				var err error
+				snapshot, err = c.createConfig(newConfigmapObj, newConfigType)
+				if err != nil {
+					admitted = false
+					failed = true
+				} else {
+					committed = true
+				}
+			}
+		}
+		if isOldTarget {
+			switch {
+			case committed:
+				c.deleteReplaced(oldConfigmapObj, oldConfigType, newConfigmapObj, newConfigType, snapshot)
+			case failed:
+				// nothing of the new version was applied, the old version stays active
+				c.trackFragments(oldConfigmapObj, oldConfigType, "")
+				if oldConfigType != overlayConst {
+					c.trackQuota(oldConfigmapObj, oldConfigmapObj, oldConfigType)
+				}
+			default:
+				c.deleteConfig(oldConfigmapObj)
 			}
 		}
 
+		// backups which become active count against the quotas as well
 		c.checkBackupConfigs()
+		c.enforceQuotas()
 
 		err := c.buildConfig()
 		if err == nil {
-			_, err = c.a.Reload()
-			if err != nil {
-				//nolint:errcheck
-				level.Error(c.logger).Log(
-					"msg", "Failed to reload alertmanager.yml",
-					"err", err.Error(),
-					"namespace", newConfigmapObj.Namespace,
-					"name", newConfigmapObj.Name,
-				)
-			} else {
-				//nolint:errcheck
-				level.Info(c.logger).Log("msg", "Succeeded: Reloaded Alertmanager")
-			}
-		} else if newAlertmanagerID == c.a.ID {
+			c.reload(newConfigmapObj)
+		} else if admitted && newConfigType == overlayConst {
+			c.deleteConfig(newConfigmapObj)
+			c.quarantineConfigMap(newConfigmapObj, newConfigType, "invalid", "invalid config: "+err.Error())
+			c.restoreReplaced(oldConfigmapObj, oldConfigType, isOldTarget, snapshot)
+		} else if admitted {
 			if newConfigType != configConst {
-				if newConfigType == routeConst || newConfigType == receiverConst || newConfigType == inhibitRuleConst {
-					c.createBackfile(newConfigmapObj, newConfigType)
+				if newConfigType == routeConst || newConfigType == receiverConst || newConfigType == inhibitRuleConst || newConfigType == bundleConst {
+					c.createBackfile(newConfigmapObj, newConfigType, err.Error())
 				}
 				c.deleteConfig(newConfigmapObj)
 			}
+			c.restoreReplaced(oldConfigmapObj, oldConfigType, isOldTarget, snapshot)
 		}
+		c.retryQuotas()
 	} else {
 		//nolint:errcheck
 		level.Debug(c.logger).Log("msg", "Skipping configmap:"+newConfigmapObj.Name)
 	}
 }
 
-// save configs(receivers, routes, inhibitrules, config template) into storage
-func (c *Controller) createConfig(configmapObj *v1.ConfigMap, configType string) {
+// save configs(receivers, routes, inhibitrules, config template) into storage,
+// either all fragments of the configmap are saved or none of them,
+// the returned snapshot holds the content of the replaced files
+func (c *Controller) createConfig(configmapObj *v1.ConfigMap, configType string) (fileSnapshot, error) {
 	var err error
-	path := ""
-
-	switch configType {
-	case routeConst:
-		path = c.a.ConfigPath + "/routes/"
-	case receiverConst:
-		path = c.a.ConfigPath + "/receivers/"
-	case inhibitRuleConst:
-		path = c.a.ConfigPath + "/inhibit-rules/"
-	case configConst:
-		path = filepath.Dir(c.a.ConfigTemplate) + "/"
-	default:
-		return
-	}
-
-	if _, err = os.Stat(path); os.IsNotExist(err) {
-		err = os.MkdirAll(path, 0766)
-		if err != nil {
-			//nolint:errcheck
-			level.Error(c.logger).Log("msg", "Failed to create directory", "err", err.Error())
+	var staged []stagedFile
+
+	for _, p := range parts(configmapObj, configType) {
+		target := c.a.ConfigPath + "/" + configDir(p.configType) + "/" + p.filename
+		staging := c.a.ConfigPath + "/staging-" + configDir(p.configType) + "/" + p.filename
+		if p.configType == configConst {
+			target = filepath.Dir(c.a.ConfigTemplate) + "/" + p.filename
+			staging = filepath.Dir(c.a.ConfigTemplate) + "/." + p.filename + ".staging"
 		}
-	}
 
-	for k, v := range configmapObj.Data {
-		filename := ""
-		if configType == configConst {
-			filename = k
-		} else {
-			filename = configmapObj.Namespace + "-" + configmapObj.Name + "-" + k
+		for _, dir := range []string{filepath.Dir(target), filepath.Dir(staging)} {
+			if _, err = os.Stat(dir); os.IsNotExist(err) {
+				err = os.MkdirAll(dir, 0766)
+				if err != nil {
+					//nolint:errcheck
+					level.Error(c.logger).Log("msg", "Failed to create directory", "err", err.Error())
+				}
+			}
 		}
 
-		if configType == routeConst {
-			v = c.addContinueIfNotExist(v)
+		v := p.content
+		if p.configType == routeConst {
+			var routes string
+			routes, err = c.addContinueIfNotExist(configmapObj, v)
+			if err != nil {
+				//nolint:errcheck
+				level.Error(c.logger).Log(
+					"msg", "Failed to parse route: "+p.key,
+					"namespace", configmapObj.Namespace,
+					"name", configmapObj.Name,
+					"err", err.Error(),
+				)
+				c.discardStaged(staged)
+				return nil, err
+			}
+			v = c.routeHeader(configmapObj, p.key) + routes
+		}
+		if p.configType == overlayConst {
+			v = c.overlayFile(configmapObj, p.key, v)
 		}
 
 		//nolint:errcheck
 		level.Info(c.logger).Log(
-			"msg", "Creating "+configType+": "+k,
+			"msg", "Creating "+p.configType+": "+p.key,
 			"namespace", configmapObj.Namespace,
 			"name", configmapObj.Name,
 		)
-		err = ioutil.WriteFile(path+filename, []byte(v), 0644)
+		err = ioutil.WriteFile(staging, []byte(v), 0644)
 		if err != nil {
 			//nolint:errcheck
 			level.Error(c.logger).Log(
-				"msg", "Failed to create "+configType+": "+k,
+				"msg", "Failed to create "+p.configType+": "+p.key,
 				"namespace", configmapObj.Namespace,
 				"name", configmapObj.Name,
+				"err", err.Error(),
 			)
+			c.discardStaged(append(staged, stagedFile{staging: staging}))
+			return nil, err
 		}
+		staged = append(staged, stagedFile{staging: staging, target: target})
 	}
-}
-
-func (c *Controller) addContinueIfNotExist(routeString string) string {
-	m := make([]map[string]interface{}, 1)
 
-	err := yaml.Unmarshal([]byte(routeString), &m)
+	snapshot, err := c.commitStaged(staged)
 	if err != nil {
 		//nolint:errcheck
-		level.Error(c.logger).Log("msg", "Format error in route string: "+routeString, "err", err.Error())
+		level.Error(c.logger).Log(
+			"msg", "Failed to apply "+configType+", no fragment of it was applied",
+			"namespace", configmapObj.Namespace,
+			"name", configmapObj.Name,
+			"err", err.Error(),
+		)
+		return nil, err
 	}
 
-	for _, route := range m {
-		if len(route) == 0 {
+	if configType != configConst {
+		c.trackFragments(configmapObj, configType, "")
+	}
+	return snapshot, nil
+}
+
+// routes of namespaces allowed by the policy may stop the evaluation with an explicit continue: false,
+// the routes are rewritten on their node tree to keep the order of keys and comments
+func (c *Controller) addContinueIfNotExist(configmapObj *v1.ConfigMap, routeString string) (string, error) {
+	allowStop := c.a.Policy.AllowStop(configmapObj.Namespace)
+	doc, err := parseRoutes(routeString)
+	if err != nil {
+		return "", err
+	}
+	if doc == nil {
+		return "[]\n", nil
+	}
+
+	routes := resolve(doc.Content[0])
+	for i, route := range routes.Content {
+		if route.Kind == yaml.AliasNode {
+			// a copy, so the route an alias points to keeps its own continue
+			route = expand(route)
+			routes.Content[i] = route
+		}
+		if len(route.Content) == 0 {
+			//nolint:errcheck
 			level.Warn(c.logger).Log("msg", "One of your route config is empty")
 			continue
 		}
-		route["continue"] = true
+		value := mappingValue(route, "continue")
+		if value == nil {
+			route.Content = append(route.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "continue"},
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+			continue
+		}
+		var continues bool
+		if value.Decode(&continues) == nil && continues {
+			continue
+		}
+		if allowStop {
+			continue
+		}
+		//nolint:errcheck
+		level.Info(c.logger).Log(
+			"msg", "Overriding continue: false, the namespace is not allowed to stop routing",
+			"namespace", configmapObj.Namespace,
+			"name", configmapObj.Name,
+		)
+		value.Kind = yaml.ScalarNode
+		value.Tag = "!!bool"
+		value.Value = "true"
+		value.Style = 0
+		value.Content = nil
+		value.Alias = nil
 	}
 
-	v, err := yaml.Marshal(&m)
-	if err != nil {
-		//nolint:errcheck
-		level.Error(c.logger).Log("msg", "Format error in route yaml", "err", err.Error())
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(doc); err != nil {
+		return "", err
+	}
+	if err = encoder.Close(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// parse a route fragment into its document node, it has to be a list of routes.
+// An empty fragment has no document node.
+func parseRoutes(routeString string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(routeString), &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	routes := resolve(doc.Content[0])
+	if routes.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("line %d: routes must be a list", routes.Line)
+	}
+	for _, route := range routes.Content {
+		if resolve(route).Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("line %d: a route must be a mapping", route.Line)
+		}
 	}
+	return &doc, nil
+}
 
-	return string(v)
+// value of a key of a mapping node, nil if the key does not exist
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
 }
 
-// backup currently unavailable configs for further usage
-func (c *Controller) createBackfile(configmapObj *v1.ConfigMap, configType string) {
-	path := c.a.ConfigPath + "/backup-" + configType + "s/"
+// check that all route fragments of a configmap can be parsed, they are quarantined otherwise
+func (c *Controller) checkRoutes(configmapObj *v1.ConfigMap, configType string) error {
+	var violations []string
+	for _, p := range parts(configmapObj, configType) {
+		if p.configType != routeConst {
+			continue
+		}
+		if _, err := parseRoutes(p.content); err != nil {
+			violations = append(violations, p.key+": "+err.Error())
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("%s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// backup currently unavailable configs for further usage, the fragments of a bundle are kept together
+func (c *Controller) createBackfile(configmapObj *v1.ConfigMap, configType string, reason string) {
+	path := c.a.ConfigPath + "/backup-" + configDir(configType) + "/"
+	if configType == bundleConst {
+		path = path + configmapObj.Namespace + "-" + configmapObj.Name + "/"
+	}
 	var err error
 	if _, err = os.Stat(path); os.IsNotExist(err) {
 		err = os.MkdirAll(path, 0766)
@@ -378,21 +662,33 @@ func (c *Controller) createBackfile(configmapObj *v1.ConfigMap, configType strin
 			level.Error(c.logger).Log("msg", "Failed to create backup directory", "err", err.Error())
 		}
 	}
-	for k, v := range configmapObj.Data {
-		filename := configmapObj.Namespace + "-" + configmapObj.Name + "-" + k
-		if configType == routeConst {
-			v = c.addContinueIfNotExist(v)
+	c.setQuotaInactive(quotaKey(configmapObj), true)
+	c.trackFragments(configmapObj, configType, reason)
+	for _, p := range parts(configmapObj, configType) {
+		filename := p.filename
+		if configType == bundleConst {
+			filename = p.key
+		}
+		v := p.content
+		if p.configType == routeConst {
+			routes, err := c.addContinueIfNotExist(configmapObj, v)
+			if err != nil {
+				//nolint:errcheck
+				level.Error(c.logger).Log("msg", "Failed to backup "+p.configType+": "+p.key, "err", err.Error())
+				continue
+			}
+			v = c.routeHeader(configmapObj, p.key) + routes
 		}
 		//nolint:errcheck
 		level.Debug(c.logger).Log(
-			"msg", "Backup "+configType+": "+k,
+			"msg", "Backup "+p.configType+": "+p.key,
 			"namespace", configmapObj.Namespace,
 			"name", configmapObj.Name,
 		)
 		err = ioutil.WriteFile(path+filename, []byte(v), 0644)
 		if err != nil {
 			//nolint:errcheck
-			level.Error(c.logger).Log("msg", "Failed to backup "+configType+": "+k, "err", err.Error())
+			level.Error(c.logger).Log("msg", "Failed to backup "+p.configType+": "+p.key, "err", err.Error())
 		}
 	}
 }
@@ -413,16 +709,11 @@ func (c *Controller) checkBackupRoutes() {
 	alertmanagerConfig.Receivers = receivers
 	alertmanagerConfig.InhibitRules = inhibitRules
 
-	configTemplate, err := ioutil.ReadFile(c.a.ConfigTemplate)
-	if err != nil {
-		//nolint:errcheck
-		level.Error(c.logger).Log("msg", "Failed to read template: "+c.a.ConfigTemplate, "err", err.Error())
-	}
-
-	t, err := template.New("alertmanager.yml").Parse(string(configTemplate))
+	t, configTemplate, err := c.parseTemplate()
 	if err != nil {
 		//nolint:errcheck
-		level.Error(c.logger).Log("msg", "Failed to parse template", "err", err.Error())
+		level.Error(c.logger).Log("msg", "Failed to parse template: "+c.a.ConfigTemplate, "err", err.Error())
+		return
 	}
 
 	for _, routeFile := range routeFiles {
@@ -435,15 +726,16 @@ func (c *Controller) checkBackupRoutes() {
 
 		alertmanagerConfig.Routes = strings.Replace(routes, "\n", "\n  ", -1)
 
-		var tpl bytes.Buffer
-		err = t.Execute(&tpl, alertmanagerConfig)
+		tpl, err := c.executeTemplate(t, configTemplate, alertmanagerConfig)
 		if err != nil {
 			//nolint:errcheck
 			level.Error(c.logger).Log("msg", "Failed to template alertmanager config", "err", err.Error())
+			continue
 		}
-		_, configErr := alcf.Load(tpl.String())
+		_, configErr := alcf.Load(string(tpl))
 		if configErr == nil {
 			c.copyFile(routeFile, c.a.ConfigPath+"/routes/"+filepath.Base(routeFile))
+			c.setFragmentQuotaInactive("routes", filepath.Base(routeFile), false)
 			err = os.Remove(routeFile)
 			if err != nil {
 				//nolint:errcheck
@@ -476,16 +768,11 @@ func (c *Controller) checkBackupReceivers() {
 	alertmanagerConfig.Routes = strings.Replace(routes, "\n", "\n  ", -1)
 	alertmanagerConfig.InhibitRules = inhibitRules
 
-	configTemplate, err := ioutil.ReadFile(c.a.ConfigTemplate)
-	if err != nil {
-		//nolint:errcheck
-		level.Error(c.logger).Log("msg", "Failed to read template: "+c.a.ConfigTemplate, "err", err.Error())
-	}
-
-	t, err := template.New("alertmanager.yml").Parse(string(configTemplate))
+	t, configTemplate, err := c.parseTemplate()
 	if err != nil {
 		//nolint:errcheck
-		level.Error(c.logger).Log("msg", "Failed to parse template", "err", err.Error())
+		level.Error(c.logger).Log("msg", "Failed to parse template: "+c.a.ConfigTemplate, "err", err.Error())
+		return
 	}
 
 	for _, receiverFile := range receiverPath {
@@ -497,15 +784,16 @@ func (c *Controller) checkBackupReceivers() {
 		newReceivers := receivers + string(receiver)
 
 		alertmanagerConfig.Receivers = newReceivers
-		var tpl bytes.Buffer
-		err = t.Execute(&tpl, alertmanagerConfig)
+		tpl, err := c.executeTemplate(t, configTemplate, alertmanagerConfig)
 		if err != nil {
 			//nolint:errcheck
 			level.Error(c.logger).Log("msg", "Failed to template alertmanager config", "err", err.Error())
+			continue
 		}
-		_, configErr := alcf.Load(tpl.String())
+		_, configErr := alcf.Load(string(tpl))
 		if configErr == nil {
 			c.copyFile(receiverFile, c.a.ConfigPath+"/receivers/"+filepath.Base(receiverFile))
+			c.setFragmentQuotaInactive("receivers", filepath.Base(receiverFile), false)
 			err = os.Remove(receiverFile)
 			if err != nil {
 				//nolint:errcheck
@@ -524,12 +812,6 @@ func (c *Controller) checkBackupReceivers() {
 
 // format config file from routs, receivers, inhibit rules and config template
 func (c *Controller) buildConfig() error {
-	configTemplate, err := ioutil.ReadFile(c.a.ConfigTemplate)
-	if err != nil {
-		//nolint:errcheck
-		level.Error(c.logger).Log("msg", "Failed to read template: "+c.a.ConfigTemplate, "err", err.Error())
-	}
-
 	routes := c.readConfigs("routes")
 	receivers := c.readConfigs("receivers")
 	inhibitRules := c.readConfigs("inhibit-rules")
@@ -539,30 +821,36 @@ func (c *Controller) buildConfig() error {
 	alertmanagerConfig.Receivers = receivers
 	alertmanagerConfig.InhibitRules = inhibitRules
 
-	t, err := template.New("alertmanager.yml").Parse(string(configTemplate))
+	t, configTemplate, err := c.parseTemplate()
 	if err != nil {
 		//nolint:errcheck
-		level.Error(c.logger).Log("msg", "Failed to parse template", "err", err.Error())
+		level.Error(c.logger).Log("msg", "Failed to parse template: "+c.a.ConfigTemplate, "err", err.Error())
+		return err
 	}
 
-	var tpl bytes.Buffer
-	err = t.Execute(&tpl, alertmanagerConfig)
+	tpl, err := c.executeTemplate(t, configTemplate, alertmanagerConfig)
 	if err != nil {
 		//nolint:errcheck
 		level.Error(c.logger).Log("msg", "Failed to template alertmanager config", "err", err.Error())
+		return err
+	}
+	config, conflicts, err := c.mergeOverlays(tpl)
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to merge overlays", "err", err.Error())
+		return err
 	}
-	_, configErr := alcf.Load(tpl.String())
+	_, configErr := alcf.Load(string(config))
 	if configErr == nil {
-		f, err := os.Create(c.a.ConfigPath + "/alertmanager.yml")
-		if err != nil {
-			//nolint:errcheck
-			level.Error(c.logger).Log("msg", "Failed to create alertmanager.yml", "err", err.Error())
+		if c.replaying {
+			return nil
 		}
-		defer f.Close()
-		err = t.Execute(f, alertmanagerConfig)
+		c.setConflicts(conflicts)
+		err = c.a.Sink.Write(config)
 		if err != nil {
 			//nolint:errcheck
-			level.Error(c.logger).Log("msg", "Failed to template alertmanager config", "err", err.Error())
+			level.Error(c.logger).Log("msg", "Failed to write alertmanager.yml to "+c.a.Sink.String(), "err", err.Error())
+			return err
 		}
 	} else {
 		//nolint:errcheck
@@ -573,13 +861,22 @@ func (c *Controller) buildConfig() error {
 
 // read config files from storage
 func (c *Controller) readConfigs(style string) string {
+	configs := ""
+	if style == "routes" {
+		// the order of routes matters, they are sorted by priority
+		_, routes := c.readRoutes()
+		for _, route := range routes {
+			configs = configs + route + "\n"
+		}
+		return configs
+	}
+
 	configFiles, err := filepath.Glob(c.a.ConfigPath + "/" + style + "/*")
 	if err != nil {
 		//nolint:errcheck
 		level.Error(c.logger).Log("msg", "Failed to read "+style, "err", err.Error())
 	}
 
-	configs := ""
 	for _, configFile := range configFiles {
 		config, err := ioutil.ReadFile(configFile)
 		if err != nil {
@@ -598,26 +895,28 @@ func (c *Controller) deleteConfig(configmapObj *v1.ConfigMap) {
 	route := configmapObj.Annotations["alertmanager.net/route"]
 	receiver := configmapObj.Annotations["alertmanager.net/receiver"]
 	inhibitRule := configmapObj.Annotations["alertmanager.net/inhibit_rule"]
+	bundle := configmapObj.Annotations["alertmanager.net/bundle"]
+	overlay := configmapObj.Annotations["alertmanager.net/overlay"]
 	isAlertmanagerRoute, _ := strconv.ParseBool(route)
 	isAlertmanagerReceiver, _ := strconv.ParseBool(receiver)
 	isAlertmanagerInhibitRule, _ := strconv.ParseBool(inhibitRule)
-	path := ""
-	configType := c.findConfigType(isAlertmanagerRoute, isAlertmanagerReceiver, isAlertmanagerInhibitRule, false)
-	path = c.a.ConfigPath + "/" + configType + "s/"
+	isAlertmanagerBundle, _ := strconv.ParseBool(bundle)
+	isAlertmanagerOverlay, _ := strconv.ParseBool(overlay)
+	configType := c.findConfigType(isAlertmanagerRoute, isAlertmanagerReceiver, isAlertmanagerInhibitRule, isAlertmanagerBundle, false, isAlertmanagerOverlay)
 
-	for k := range configmapObj.Data {
-		filename := configmapObj.Namespace + "-" + configmapObj.Name + "-" + k
+	for _, p := range parts(configmapObj, configType) {
+		path := c.a.ConfigPath + "/" + configDir(p.configType) + "/"
 		//nolint:errcheck
 		level.Info(c.logger).Log(
-			"msg", "Deleting "+configType+": "+k,
+			"msg", "Deleting "+p.configType+": "+p.key,
 			"namespace", configmapObj.Namespace,
 			"name", configmapObj.Name,
 		)
-		err = os.Remove(path + filename)
+		err = os.Remove(path + p.filename)
 		if err != nil {
 			//nolint:errcheck
 			level.Error(c.logger).Log(
-				"msg", "Failed to delete "+configType+": "+k,
+				"msg", "Failed to delete "+p.configType+": "+p.key,
 				"namespace", configmapObj.Namespace,
 				"name", configmapObj.Name,
 				"err", err.Error(),
@@ -626,23 +925,35 @@ func (c *Controller) deleteConfig(configmapObj *v1.ConfigMap) {
 	}
 }
 
-// remove config files from backup storage
+// remove config files from backup and quarantine storage, as their configmap is gone
 func (c *Controller) deleteBackupFile(configmapObj *v1.ConfigMap, configType string) {
-	for k := range configmapObj.Data {
-		filename := configmapObj.Namespace + "-" + configmapObj.Name + "-" + k
+	if configType == bundleConst {
+		path := c.a.ConfigPath + "/backup-" + bundleDir + "/" + configmapObj.Namespace + "-" + configmapObj.Name
 		//nolint:errcheck
-		level.Debug(c.logger).Log("mag", "Delete backup "+configType+" if it is existed")
-		if _, err := os.Stat(c.a.ConfigPath + "/backup-" + configType + "s/" + filename); !os.IsNotExist(err) {
-			err := os.Remove(c.a.ConfigPath + "/backup-" + configType + "s/" + filename)
+		level.Debug(c.logger).Log("msg", "Delete backup "+configType+" if it is existed")
+		err := os.RemoveAll(path)
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to delete backup "+configType+": "+path, "err", err.Error())
+		}
+	}
+	for _, p := range parts(configmapObj, configType) {
+		backupFile := c.a.ConfigPath + "/backup-" + configDir(p.configType) + "/" + p.filename
+		//nolint:errcheck
+		level.Debug(c.logger).Log("mag", "Delete backup "+p.configType+" if it is existed")
+		if _, err := os.Stat(backupFile); !os.IsNotExist(err) {
+			err := os.Remove(backupFile)
 			if err != nil {
 				//nolint:errcheck
-				level.Error(c.logger).Log("msg", "Failed to delete backup "+configType+": "+filename, "err", err.Error())
+				level.Error(c.logger).Log("msg", "Failed to delete backup "+p.configType+": "+p.filename, "err", err.Error())
 			}
 		} else {
 			//nolint:errcheck
-			level.Debug(c.logger).Log("msg", "Backup "+configType+" does not exist")
+			level.Debug(c.logger).Log("msg", "Backup "+p.configType+" does not exist")
 		}
+		c.deleteQuarantinedFile(p.filename, p.configType)
 	}
+	c.untrackFragments(configmapObj, configType)
 }
 
 // are two configmaps same
@@ -704,16 +1015,11 @@ func (c *Controller) checkBackupInhibitRules() {
 	alertmanagerConfig.Routes = strings.Replace(routes, "\n", "\n  ", -1)
 	alertmanagerConfig.Receivers = receivers
 
-	configTemplate, err := ioutil.ReadFile(c.a.ConfigTemplate)
+	t, configTemplate, err := c.parseTemplate()
 	if err != nil {
 		//nolint:errcheck
-		level.Error(c.logger).Log("msg", "Failed to read template: "+c.a.ConfigTemplate, "err", err.Error())
-	}
-
-	t, err := template.New("alertmanager.yml").Parse(string(configTemplate))
-	if err != nil {
-		//nolint:errcheck
-		level.Error(c.logger).Log("msg", "Failed to parse template", "err", err.Error())
+		level.Error(c.logger).Log("msg", "Failed to parse template: "+c.a.ConfigTemplate, "err", err.Error())
+		return
 	}
 
 	for _, inhibitRuleFile := range inhibitRulePath {
@@ -725,15 +1031,16 @@ func (c *Controller) checkBackupInhibitRules() {
 		newInhibitRules := inhibitRules + string(inhibitRule)
 
 		alertmanagerConfig.InhibitRules = newInhibitRules
-		var tpl bytes.Buffer
-		err = t.Execute(&tpl, alertmanagerConfig)
+		tpl, err := c.executeTemplate(t, configTemplate, alertmanagerConfig)
 		if err != nil {
 			//nolint:errcheck
 			level.Error(c.logger).Log("msg", "Failed to template alertmanager config", "err", err.Error())
+			continue
 		}
-		_, configErr := alcf.Load(tpl.String())
+		_, configErr := alcf.Load(string(tpl))
 		if configErr == nil {
 			c.copyFile(inhibitRuleFile, c.a.ConfigPath+"/inhibit-rules/"+filepath.Base(inhibitRuleFile))
+			c.setFragmentQuotaInactive("inhibit-rules", filepath.Base(inhibitRuleFile), false)
 			err = os.Remove(inhibitRuleFile)
 			if err != nil {
 				//nolint:errcheck
@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/yaml.v3"
+)
+
+// values of these keys are replaced in the output of the debug API
+var secretKeys = map[string]bool{
+	"smtp_auth_password": true,
+	"smtp_auth_secret":   true,
+	"smtp_auth_identity": true,
+	"slack_api_url":      true,
+	"hipchat_auth_token": true,
+	"opsgenie_api_key":   true,
+	"victorops_api_key":  true,
+	"wechat_api_secret":  true,
+	"api_url":            true,
+	"api_key":            true,
+	"api_secret":         true,
+	"auth_token":         true,
+	"service_key":        true,
+	"routing_key":        true,
+	"user_key":           true,
+	"token":              true,
+	"bot_token":          true,
+	"bearer_token":       true,
+	"password":           true,
+	"secret":             true,
+	"webhook_url":        true,
+	"url":                true,
+}
+
+// a line starting with a possibly quoted key, the value is the rest of the line
+var keyLine = regexp.MustCompile(`^(\s*(?:-\s+)?)("[A-Za-z_]+"|'[A-Za-z_]+'|[A-Za-z_]+)(\s*:)(.*)$`)
+
+// a value continued on the following lines, a block scalar or nothing
+var continuedValue = regexp.MustCompile(`^\s*(?:[|>][-+0-9]*)?\s*(?:#.*)?$`)
+
+// document returned by the config and template endpoints
+type document struct {
+	Source  string `json:"source"`
+	Content string `json:"content"`
+}
+
+// Handler serves the read-only debug API of the controller
+func (c *Controller) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/fragments", c.serveFragments)
+	mux.HandleFunc("/api/v1/rejected", c.serveRejections)
+	mux.HandleFunc("/api/v1/config", c.serveConfig)
+	mux.HandleFunc("/api/v1/template", c.serveTemplate)
+	mux.HandleFunc("/api/v1/overlays", c.serveOverlays)
+	mux.HandleFunc("/api/v1/routes", c.serveRoutes)
+	return mux
+}
+
+func (c *Controller) serveFragments(w http.ResponseWriter, r *http.Request) {
+	c.writeJSON(w, c.Fragments())
+}
+
+func (c *Controller) serveRejections(w http.ResponseWriter, r *http.Request) {
+	c.writeJSON(w, c.Rejections())
+}
+
+func (c *Controller) serveRoutes(w http.ResponseWriter, r *http.Request) {
+	c.writeJSON(w, c.Routes())
+}
+
+// active overlays in the order they are merged
+type overlays struct {
+	Overlays  []Overlay  `json:"overlays"`
+	Conflicts []Conflict `json:"conflicts"`
+}
+
+func (c *Controller) serveOverlays(w http.ResponseWriter, r *http.Request) {
+	o, conflicts := c.Overlays()
+	c.writeJSON(w, overlays{Overlays: o, Conflicts: conflicts})
+}
+
+func (c *Controller) serveConfig(w http.ResponseWriter, r *http.Request) {
+	content, err := c.a.Sink.Read()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	c.writeJSON(w, document{Source: c.a.Sink.String(), Content: redact(string(content))})
+}
+
+func (c *Controller) serveTemplate(w http.ResponseWriter, r *http.Request) {
+	c.serveFile(w, c.a.ConfigTemplate)
+}
+
+func (c *Controller) serveFile(w http.ResponseWriter, path string) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	c.writeJSON(w, document{Source: path, Content: redact(string(content))})
+}
+
+func (c *Controller) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(v)
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to write debug response", "err", err.Error())
+	}
+}
+
+// hide the values of secret-bearing fields. YAML is redacted on the parsed
+// document by key name, templates and unparsable content line by line.
+func redact(content string) string {
+	if strings.Contains(content, "{{") {
+		return redactLines(content)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil || len(doc.Content) == 0 {
+		return redactLines(content)
+	}
+	redactNode(&doc)
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return redactLines(content)
+	}
+	if err := encoder.Close(); err != nil {
+		return redactLines(content)
+	}
+	return out.String()
+}
+
+// replace the values of secret keys in the node tree, whatever their kind
+func redactNode(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value := node.Content[i+1]
+			if secretKeys[node.Content[i].Value] {
+				value.Kind = yaml.ScalarNode
+				value.Tag = "!!str"
+				value.Value = "<secret>"
+				value.Style = 0
+				value.Content = nil
+				value.Alias = nil
+				continue
+			}
+			redactNode(value)
+		}
+		return
+	}
+	for _, n := range node.Content {
+		redactNode(n)
+	}
+}
+
+// replace the values of secret keys line by line, values continued on the
+// following lines like block scalars are dropped up to the next line which
+// is not indented deeper than the key
+func redactLines(content string) string {
+	lines := strings.Split(content, "\n")
+	out := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); i++ {
+		match := keyLine.FindStringSubmatch(lines[i])
+		if match == nil || !secretKeys[strings.Trim(match[2], `"'`)] {
+			out = append(out, lines[i])
+			continue
+		}
+		out = append(out, match[1]+match[2]+match[3]+" <secret>")
+		if !continuedValue.MatchString(match[4]) {
+			continue
+		}
+		indent := len(match[1])
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) == "" {
+				continue
+			}
+			if indentation(lines[j]) <= indent {
+				break
+			}
+			i = j
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// number of leading spaces of a line
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
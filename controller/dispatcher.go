@@ -0,0 +1,65 @@
+package controller
+
+import "sync"
+
+// Dispatcher hands the events of a single informer to the controllers of all Alertmanager setups,
+// each controller only picks the configmaps targeting its own setup. With a Queue the events are
+// handled one after another by its worker, without one they are handled right away.
+type Dispatcher struct {
+	Controllers []*Controller
+	Queue       *Queue
+	mu          sync.RWMutex
+}
+
+// SetControllers replaces the controllers, events handled afterwards go to the new ones
+func (d *Dispatcher) SetControllers(controllers []*Controller) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Controllers = controllers
+}
+
+func (d *Dispatcher) controllers() []*Controller {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.Controllers
+}
+
+// Create is called when a configmap is created
+func (d *Dispatcher) Create(obj interface{}) {
+	d.dispatch(func() {
+		for _, c := range d.controllers() {
+			c.Create(obj)
+		}
+	})
+}
+
+// Update is called when a configmap is updated
+func (d *Dispatcher) Update(oldobj, newobj interface{}) {
+	d.dispatch(func() {
+		for _, c := range d.controllers() {
+			c.Update(oldobj, newobj)
+		}
+	})
+}
+
+// Delete is called when a configmap is deleted
+func (d *Dispatcher) Delete(obj interface{}) {
+	d.dispatch(func() {
+		for _, c := range d.controllers() {
+			c.Delete(obj)
+		}
+	})
+}
+
+func (d *Dispatcher) dispatch(handle func()) {
+	dispatch(d.Queue, handle)
+}
+
+// add an event to the queue, without a queue it is handled right away
+func dispatch(queue *Queue, handle func()) {
+	if queue == nil {
+		handle()
+		return
+	}
+	queue.Add(handle)
+}
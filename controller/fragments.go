@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+var (
+	stateActive      = "active"
+	stateBackup      = "backup"
+	stateQuarantined = "quarantined"
+)
+
+// Fragment is a route, receiver or inhibit rule taken from a configmap
+type Fragment struct {
+	Type      string    `json:"type"`
+	File      string    `json:"file"`
+	Namespace string    `json:"namespace,omitempty"`
+	ConfigMap string    `json:"configmap,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	State     string    `json:"state"`
+	Reason    string    `json:"reason,omitempty"`
+	Hash      string    `json:"hash"`
+	Size      int       `json:"size"`
+	Updated   time.Time `json:"updated"`
+}
+
+// origin of a fragment file
+type source struct {
+	namespace string
+	name      string
+	key       string
+	reason    string
+	bundle    bool
+	updated   time.Time
+}
+
+// remember which configmap a fragment file was created from
+func (c *Controller) trackFragments(configmapObj *v1.ConfigMap, configType string, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range parts(configmapObj, configType) {
+		c.sources[configDir(p.configType)+"/"+p.filename] = source{
+			namespace: configmapObj.Namespace,
+			name:      configmapObj.Name,
+			key:       p.key,
+			reason:    reason,
+			bundle:    configType == bundleConst,
+			updated:   time.Now(),
+		}
+	}
+}
+
+// forget the fragment files of a configmap
+func (c *Controller) untrackFragments(configmapObj *v1.ConfigMap, configType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range parts(configmapObj, configType) {
+		delete(c.sources, configDir(p.configType)+"/"+p.filename)
+	}
+}
+
+// is a fragment file one of the fragments of a configmap
+func (c *Controller) fromConfigMap(dir string, filename string, configmapObj *v1.ConfigMap) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s, ok := c.sources[dir+"/"+filename]
+	return ok && s.namespace == configmapObj.Namespace && s.name == configmapObj.Name
+}
+
+// record why a fragment is not active
+func (c *Controller) setFragmentReason(dir string, filename string, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.sources[dir+"/"+filename]
+	s.reason = reason
+	s.updated = time.Now()
+	c.sources[dir+"/"+filename] = s
+}
+
+// Fragments lists all fragments in active, backup and quarantine storage
+func (c *Controller) Fragments() []Fragment {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var fragments []Fragment
+	for _, configType := range []string{routeConst, receiverConst, inhibitRuleConst} {
+		dir := configDir(configType)
+		states := map[string]string{
+			dir:                 stateActive,
+			"backup-" + dir:     stateBackup,
+			"quarantine-" + dir: stateQuarantined,
+		}
+		for storage, state := range states {
+			files, _ := filepath.Glob(c.a.ConfigPath + "/" + storage + "/*")
+			for _, file := range files {
+				if fragment, ok := c.fragment(file, storage, configType, filepath.Base(file), state); ok {
+					fragments = append(fragments, fragment)
+				}
+			}
+		}
+	}
+	// backup bundles keep their fragments together, named by the key of the bundle
+	files, _ := filepath.Glob(c.a.ConfigPath + "/backup-" + bundleDir + "/*/*")
+	for _, file := range files {
+		configType, ok := bundleKeys[filepath.Base(file)]
+		if !ok {
+			continue
+		}
+		filename := filepath.Base(filepath.Dir(file)) + "-" + filepath.Base(file)
+		storage := "backup-" + bundleDir + "/" + filepath.Base(filepath.Dir(file))
+		if fragment, ok := c.fragment(file, storage, configType, filename, stateBackup); ok {
+			fragments = append(fragments, fragment)
+		}
+	}
+	sort.Slice(fragments, func(i, j int) bool {
+		return fragments[i].File < fragments[j].File
+	})
+	return fragments
+}
+
+// describe a fragment file, filename is its name in the active storage of configType
+func (c *Controller) fragment(file string, storage string, configType string, filename string, state string) (Fragment, bool) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Fragment{}, false
+	}
+	fragment := Fragment{
+		Type:  configType,
+		File:  storage + "/" + filepath.Base(file),
+		State: state,
+		Hash:  hashContent(content),
+		Size:  len(content),
+	}
+	if s, ok := c.sources[configDir(configType)+"/"+filename]; ok {
+		fragment.Namespace = s.namespace
+		fragment.ConfigMap = s.name
+		fragment.Key = s.key
+		fragment.Updated = s.updated
+		if state != stateActive {
+			fragment.Reason = s.reason
+		}
+	} else if info, err := os.Stat(file); err == nil {
+		fragment.Updated = info.ModTime()
+	}
+	return fragment, true
+}
@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	"github.com/go-kit/kit/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// template of alertmanager.yml used by the tests
+var testTemplate = `global:
+  resolve_timeout: 5m
+route:
+  receiver: dummy
+  routes:
+  {{ .Routes }}
+receivers:
+- name: dummy
+{{ .Receivers }}
+inhibit_rules:
+{{ .InhibitRules }}
+`
+
+// testSetup is a controller on a temporary config path with a fake Alertmanager,
+// which rejects every config containing reject
+type testSetup struct {
+	t       *testing.T
+	dir     string
+	c       *Controller
+	server  *httptest.Server
+	mu      sync.Mutex
+	reject  string
+	reloads int
+}
+
+func newTestSetup(t *testing.T) *testSetup {
+	return newTestSetupWith(t, func(a *alertmanager.APIClient) {})
+}
+
+// create a test setup, configure can change the client before the controller is created
+func newTestSetupWith(t *testing.T, configure func(a *alertmanager.APIClient)) *testSetup {
+	dir, err := ioutil.TempDir("", "controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSetup{t: t, dir: dir}
+	s.writeFile("template/alertmanager.tmpl", testTemplate)
+	s.server = httptest.NewServer(http.HandlerFunc(s.reload))
+
+	URL, _ := url.Parse(s.server.URL + "/-/reload")
+	a := alertmanager.New(URL, dir+"/config", dir+"/template/alertmanager.tmpl", "0", "key", log.NewNopLogger())
+	a.HistoryLimit = 10
+	configure(a)
+	err = os.MkdirAll(a.ConfigPath, 0766)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.c = New(*a, log.NewNopLogger())
+	return s
+}
+
+func (s *testSetup) close() {
+	s.server.Close()
+	os.RemoveAll(s.dir)
+}
+
+func (s *testSetup) reload(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloads++
+	config, _ := s.c.a.Sink.Read()
+	if s.reject != "" && strings.Contains(string(config), s.reject) {
+		http.Error(w, "rejected", http.StatusBadRequest)
+	}
+}
+
+// let the fake Alertmanager reject every config containing reject
+func (s *testSetup) rejectConfigs(reject string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reject = reject
+}
+
+// the published alertmanager.yml, empty if there is none
+func (s *testSetup) config() string {
+	config, err := s.c.a.Sink.Read()
+	if err != nil {
+		return ""
+	}
+	return string(config)
+}
+
+// write a file relative to the temporary directory
+func (s *testSetup) writeFile(file string, content string) {
+	path := filepath.Join(s.dir, file)
+	err := os.MkdirAll(filepath.Dir(path), 0766)
+	if err == nil {
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+	}
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// read a file relative to the config path, empty if it does not exist
+func (s *testSetup) readFile(file string) string {
+	content, err := ioutil.ReadFile(filepath.Join(s.c.a.ConfigPath, file))
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
+
+// does a file relative to the config path exist
+func (s *testSetup) exists(file string) bool {
+	_, err := os.Stat(filepath.Join(s.c.a.ConfigPath, file))
+	return err == nil
+}
+
+// names of the files in a directory relative to the config path
+func (s *testSetup) files(dir string) []string {
+	files, _ := filepath.Glob(filepath.Join(s.c.a.ConfigPath, dir, "*"))
+	names := []string{}
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	return names
+}
+
+// a configmap in namespace with the annotations, given as pairs of key and value
+func configMap(namespace string, name string, data map[string]string, annotations ...string) *v1.ConfigMap {
+	configmapObj := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: make(map[string]string),
+		},
+		Data: data,
+	}
+	for i := 0; i+1 < len(annotations); i += 2 {
+		configmapObj.Annotations[annotations[i]] = annotations[i+1]
+	}
+	return configmapObj
+}
+
+// a receiver configmap with a single webhook receiver
+func receiverConfigMap(namespace string, name string, receiver string) *v1.ConfigMap {
+	return configMap(namespace, name, map[string]string{
+		"receiver.yaml": "- name: " + receiver + "\n  webhook_configs:\n  - url: http://" + receiver + "\n",
+	}, "alertmanager.net/receiver", "true")
+}
+
+// a route configmap with a single route to receiver
+func routeConfigMap(namespace string, name string, receiver string) *v1.ConfigMap {
+	return configMap(namespace, name, map[string]string{
+		"route.yaml": "- receiver: " + receiver + "\n  match:\n    team: " + name + "\n",
+	}, "alertmanager.net/route", "true")
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// the template of alertmanager.yml
+func (s *testSetup) readTemplate() (string, error) {
+	content, err := ioutil.ReadFile(s.c.a.ConfigTemplate)
+	return string(content), err
+}
+
+// sign a configmap with the key of the test setup
+func signed(configmapObj *v1.ConfigMap) *v1.ConfigMap {
+	configmapObj.Annotations["alertmanager.net/signature"] = Signature("key", configmapObj)
+	return configmapObj
+}
@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"strings"
+
+	"github.com/dbsystel/alertmanager-config-controller/setup"
+	"github.com/go-kit/kit/log/level"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var (
+	// idLabel is the label the ids of Alertmanager setups are matched as by an id selector
+	idLabel = "id"
+	// defaultID is the id of a configmap without alertmanager.net/id annotation
+	defaultID = "0"
+)
+
+// does the alertmanager.net/id annotation of a configmap target this Alertmanager setup,
+// it is either a single id, a list like "platform,team-payments", the wildcard "*" or a selector like "id in (0,2)"
+func (c *Controller) matchesID(configmapObj *v1.ConfigMap) bool {
+	id, ok := configmapObj.Annotations["alertmanager.net/id"]
+	if !ok {
+		return defaultID == c.a.ID
+	}
+	id = strings.TrimSpace(id)
+	switch {
+	case id == "*":
+		return true
+	case strings.ContainsAny(id, "=!()") || strings.Contains(id, " in ") || strings.Contains(id, " notin "):
+		selector, err := labels.Parse(id)
+		if err != nil {
+			c.warnInvalidID(configmapObj, id, err.Error())
+			return false
+		}
+		return selector.Matches(labels.Set{idLabel: c.a.ID})
+	default:
+		matches := false
+		for _, i := range strings.Split(id, ",") {
+			i = strings.TrimSpace(i)
+			if err := setup.ValidateID(i); err != "" {
+				c.warnInvalidID(configmapObj, i, err)
+				continue
+			}
+			if i == c.a.ID {
+				matches = true
+			}
+		}
+		return matches
+	}
+}
+
+func (c *Controller) warnInvalidID(configmapObj *v1.ConfigMap, id string, reason string) {
+	//nolint:errcheck
+	level.Warn(c.logger).Log(
+		"msg", "Ignoring invalid id: "+id,
+		"namespace", configmapObj.Namespace,
+		"name", configmapObj.Name,
+		"reason", reason,
+	)
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+)
+
+func TestMatchesID(t *testing.T) {
+	cases := []struct {
+		name string
+		// id of the setup and alertmanager.net/id of the configmap, "-" if it has none
+		setup string
+		id    string
+		want  bool
+	}{
+		{"default id", "0", "-", true},
+		{"other setup without annotation", "platform", "-", false},
+		{"single id", "platform", "platform", true},
+		{"other single id", "platform", "team-payments", false},
+		{"list", "team-payments", "platform, team-payments", true},
+		{"list without the id", "team-orders", "platform,team-payments", false},
+		{"list with an invalid id", "platform", "platform,not valid", true},
+		{"wildcard", "team-orders", "*", true},
+		{"in selector", "2", "id in (0,2)", true},
+		{"notin selector", "2", "id notin (0,2)", false},
+		{"inequality selector", "platform", "id!=team-payments", true},
+		{"invalid selector", "platform", "id in (platform", false},
+		{"invalid id", "0", "not valid", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+				a.ID = tc.setup
+			})
+			defer s.close()
+			configmapObj := receiverConfigMap("team-a", "r", "r")
+			if tc.id != "-" {
+				configmapObj.Annotations["alertmanager.net/id"] = tc.id
+			}
+			if got := s.c.matchesID(configmapObj); got != tc.want {
+				t.Errorf("matchesID(%q) of setup %q = %v, want %v", tc.id, tc.setup, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIDChange(t *testing.T) {
+	s := newTestSetup(t)
+	defer s.close()
+	old := receiverConfigMap("team-a", "r", "r")
+	old.Annotations["alertmanager.net/id"] = "0,1"
+	s.c.Create(old)
+	if got := s.files("receivers"); len(got) != 1 {
+		t.Fatalf("receivers = %v, want the receiver of the configmap", got)
+	}
+
+	moved := receiverConfigMap("team-a", "r", "r")
+	moved.Annotations["alertmanager.net/id"] = "1"
+	s.c.Update(old, moved)
+	if got := s.files("receivers"); len(got) != 0 {
+		t.Errorf("receivers = %v, want none after the configmap no longer targets the setup", got)
+	}
+
+	s.c.Update(moved, old)
+	if got := s.files("receivers"); len(got) != 1 {
+		t.Errorf("receivers = %v, want the receiver after the configmap targets the setup again", got)
+	}
+}
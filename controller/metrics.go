@@ -0,0 +1,27 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rejectedConfigMaps = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alertmanager_config_controller_rejected_configmaps_total",
+			Help: "Number of configmaps rejected by the controller.",
+		},
+		[]string{"type", "reason"},
+	)
+	overlayConflicts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "alertmanager_config_controller_overlay_conflicts",
+			Help: "Number of fields set by more than one overlay in the last built config.",
+		},
+		[]string{"id"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rejectedConfigMaps)
+	prometheus.MustRegister(overlayConflicts)
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// annotation or label of a namespace with the receiver of its default route
+var teamReceiverKey = "alertmanager.net/team-receiver"
+
+// NamespaceHandler hands the events of the namespace informer to the controllers of all Alertmanager setups,
+// with a Queue they are handled by its worker one after another with the events of the configmaps
+type NamespaceHandler struct {
+	Controllers []*Controller
+	Queue       *Queue
+	mu          sync.RWMutex
+}
+
+// SetControllers replaces the controllers, events handled afterwards go to the new ones
+func (h *NamespaceHandler) SetControllers(controllers []*Controller) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Controllers = controllers
+}
+
+func (h *NamespaceHandler) controllers() []*Controller {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.Controllers
+}
+
+// Create is called when a namespace is created
+func (h *NamespaceHandler) Create(obj interface{}) {
+	namespace := obj.(*v1.Namespace)
+	h.setTeamReceiver(namespace.Name, teamReceiver(namespace))
+}
+
+// Update is called when a namespace is updated
+func (h *NamespaceHandler) Update(oldobj, newobj interface{}) {
+	namespace := newobj.(*v1.Namespace)
+	h.setTeamReceiver(namespace.Name, teamReceiver(namespace))
+}
+
+// Delete is called when a namespace is deleted
+func (h *NamespaceHandler) Delete(obj interface{}) {
+	namespace, ok := obj.(*v1.Namespace)
+	if !ok {
+		return
+	}
+	h.setTeamReceiver(namespace.Name, "")
+}
+
+func (h *NamespaceHandler) setTeamReceiver(namespace string, receiver string) {
+	dispatch(h.Queue, func() {
+		for _, c := range h.controllers() {
+			if c.setTeamReceiver(namespace, receiver) {
+				c.resync(namespace)
+			}
+		}
+	})
+}
+
+// receiver of the default route of a namespace, the annotation wins over the label
+func teamReceiver(namespace *v1.Namespace) string {
+	if receiver, ok := namespace.Annotations[teamReceiverKey]; ok {
+		return receiver
+	}
+	return namespace.Labels[teamReceiverKey]
+}
+
+// remember the team receiver of a namespace, whether it changed
+func (c *Controller) setTeamReceiver(namespace string, receiver string) bool {
+	if !c.a.NamespaceRoutes {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.teamReceivers[namespace] == receiver {
+		return false
+	}
+	if receiver == "" {
+		delete(c.teamReceivers, namespace)
+	} else {
+		c.teamReceivers[namespace] = receiver
+	}
+	//nolint:errcheck
+	level.Info(c.logger).Log("msg", "Changed default route of namespace", "namespace", namespace, "receiver", receiver)
+	return true
+}
+
+// build the config again after the default route of a namespace changed
+func (c *Controller) resync(namespace string) {
+	// namespaces are no configmaps, the trigger in the history names the namespace instead
+	trigger := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "namespace"}}
+	err := c.buildConfig()
+	if err == nil {
+		c.reload(trigger)
+	}
+}
+
+// default routes of all namespaces with a team receiver, which have no routes of their own,
+// routes to receivers which do not exist are left out
+func (c *Controller) namespaceRoutes(receivers []string) string {
+	if !c.a.NamespaceRoutes {
+		return ""
+	}
+	defined := make(map[string]bool)
+	for _, r := range receivers {
+		defined[r] = true
+	}
+	explicit := make(map[string]bool)
+	for _, ns := range c.routeNamespaces() {
+		explicit[ns] = true
+	}
+
+	c.mu.RLock()
+	namespaces := make([]string, 0, len(c.teamReceivers))
+	for ns := range c.teamReceivers {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	var routes []map[string]interface{}
+	for _, ns := range namespaces {
+		receiver := c.teamReceivers[ns]
+		if explicit[ns] {
+			continue
+		}
+		if !defined[receiver] {
+			//nolint:errcheck
+			level.Debug(c.logger).Log("msg", "Skipping default route to unknown receiver "+receiver, "namespace", ns)
+			continue
+		}
+		routes = append(routes, map[string]interface{}{
+			"receiver": receiver,
+			"match":    map[string]string{"namespace": ns},
+			"continue": true,
+		})
+	}
+	c.mu.RUnlock()
+
+	if len(routes) == 0 {
+		return ""
+	}
+	out, err := yaml.Marshal(routes)
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to generate default routes of namespaces", "err", err.Error())
+		return ""
+	}
+	return strings.Replace(string(out), "\n", "\n  ", -1)
+}
+
+// namespaces of all configmaps with active routes
+func (c *Controller) routeNamespaces() []string {
+	return c.namespacesOf([]string{configDir(routeConst)})
+}
+
+// names of the receivers defined in a rendered config
+func definedReceivers(config []byte) []string {
+	var parsed struct {
+		Receivers []struct {
+			Name string `yaml:"name"`
+		} `yaml:"receivers"`
+	}
+	_ = yaml.Unmarshal(config, &parsed)
+	var names []string
+	for _, r := range parsed.Receivers {
+		names = append(names, r.Name)
+	}
+	return names
+}
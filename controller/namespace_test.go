@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func namespace(name string, receiver string) *v1.Namespace {
+	namespaceObj := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if receiver != "" {
+		namespaceObj.Annotations = map[string]string{teamReceiverKey: receiver}
+	}
+	return namespaceObj
+}
+
+func TestNamespaceRoutes(t *testing.T) {
+	cases := []struct {
+		name            string
+		namespaceRoutes bool
+		run             func(s *testSetup, h *NamespaceHandler)
+		// the published alertmanager.yml contains, or does not contain with a leading !
+		config []string
+		// reloads of Alertmanager triggered by the namespaces
+		reloads int
+	}{
+		{
+			name:            "default route of a namespace",
+			namespaceRoutes: true,
+			run: func(s *testSetup, h *NamespaceHandler) {
+				h.Create(namespace("team-a", "team-a-receiver"))
+			},
+			config:  []string{"- continue: true\n    match:\n      namespace: team-a\n    receiver: team-a-receiver\n"},
+			reloads: 1,
+		},
+		{
+			name:            "label of a namespace",
+			namespaceRoutes: true,
+			run: func(s *testSetup, h *NamespaceHandler) {
+				namespaceObj := namespace("team-a", "")
+				namespaceObj.Labels = map[string]string{teamReceiverKey: "team-a-receiver"}
+				h.Create(namespaceObj)
+			},
+			config:  []string{"namespace: team-a"},
+			reloads: 1,
+		},
+		{
+			name:            "unchanged namespace is not built again",
+			namespaceRoutes: true,
+			run: func(s *testSetup, h *NamespaceHandler) {
+				h.Create(namespace("team-a", "team-a-receiver"))
+				h.Update(namespace("team-a", "team-a-receiver"), namespace("team-a", "team-a-receiver"))
+			},
+			config:  []string{"namespace: team-a"},
+			reloads: 1,
+		},
+		{
+			name:            "deleted namespace",
+			namespaceRoutes: true,
+			run: func(s *testSetup, h *NamespaceHandler) {
+				h.Create(namespace("team-a", "team-a-receiver"))
+				h.Delete(namespace("team-a", "team-a-receiver"))
+			},
+			config:  []string{"!namespace: team-a"},
+			reloads: 2,
+		},
+		{
+			name:            "unknown receiver",
+			namespaceRoutes: true,
+			run: func(s *testSetup, h *NamespaceHandler) {
+				h.Create(namespace("team-b", "missing"))
+			},
+			config:  []string{"!namespace: team-b"},
+			reloads: 1,
+		},
+		{
+			name:            "namespace with routes of its own",
+			namespaceRoutes: true,
+			run: func(s *testSetup, h *NamespaceHandler) {
+				s.c.Create(routeConfigMap("team-a", "own", "team-a-receiver"))
+				h.Create(namespace("team-a", "team-a-receiver"))
+			},
+			config:  []string{"!namespace: team-a"},
+			reloads: 2,
+		},
+		{
+			name: "disabled namespace routes",
+			run: func(s *testSetup, h *NamespaceHandler) {
+				h.Create(namespace("team-a", "team-a-receiver"))
+			},
+			config: []string{"!namespace: team-a"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+				a.NamespaceRoutes = tc.namespaceRoutes
+			})
+			defer s.close()
+			s.c.Create(receiverConfigMap("team-a", "receiver", "team-a-receiver"))
+			reloads := s.reloads
+
+			queue := NewQueue()
+			go queue.Run()
+			defer queue.ShutDown()
+			h := &NamespaceHandler{Controllers: []*Controller{s.c}, Queue: queue}
+			tc.run(s, h)
+			done := make(chan struct{})
+			queue.Add(func() { close(done) })
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("queue did not handle the events")
+			}
+
+			config := s.config()
+			for _, c := range tc.config {
+				if strings.HasPrefix(c, "!") && strings.Contains(config, c[1:]) {
+					t.Errorf("config contains %q:\n%s", c[1:], config)
+				}
+				if !strings.HasPrefix(c, "!") && !strings.Contains(config, c) {
+					t.Errorf("config does not contain %q:\n%s", c, config)
+				}
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.reloads-reloads != tc.reloads {
+				t.Errorf("%d reloads, want %d", s.reloads-reloads, tc.reloads)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+)
+
+var overlayConst = "overlay"
+
+// Overlay is a part of the global config merged into the rendered template,
+// overlays with a higher precedence win over lower ones
+type Overlay struct {
+	Precedence int    `yaml:"precedence" json:"precedence"`
+	Namespace  string `yaml:"namespace" json:"namespace"`
+	ConfigMap  string `yaml:"configmap" json:"configmap"`
+	Key        string `yaml:"key" json:"key"`
+	Content    string `yaml:"content" json:"-"`
+}
+
+// Conflict of two overlays setting the same field to different values
+type Conflict struct {
+	Path   string `json:"path"`
+	Winner string `json:"winner"`
+	Loser  string `json:"loser"`
+}
+
+func (o Overlay) String() string {
+	return o.Namespace + "/" + o.ConfigMap + "/" + o.Key
+}
+
+// overlay file of a key of an overlay configmap, which keeps the origin and precedence next to the content
+func (c *Controller) overlayFile(configmapObj *v1.ConfigMap, key string, content string) string {
+	o, _ := yaml.Marshal(Overlay{
+		Precedence: c.intAnnotation(configmapObj, "alertmanager.net/precedence"),
+		Namespace:  configmapObj.Namespace,
+		ConfigMap:  configmapObj.Name,
+		Key:        key,
+		Content:    content,
+	})
+	return string(o)
+}
+
+// read all active overlays in the order they are merged
+func (c *Controller) readOverlays() []Overlay {
+	files, err := filepath.Glob(c.a.ConfigPath + "/" + configDir(overlayConst) + "/*")
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to read overlays", "err", err.Error())
+	}
+
+	var overlays []Overlay
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to read overlay file "+file, "err", err.Error())
+			continue
+		}
+		var o Overlay
+		err = yaml.Unmarshal(content, &o)
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to parse overlay file "+file, "err", err.Error())
+			continue
+		}
+		overlays = append(overlays, o)
+	}
+	sort.SliceStable(overlays, func(i, j int) bool {
+		if overlays[i].Precedence != overlays[j].Precedence {
+			return overlays[i].Precedence < overlays[j].Precedence
+		}
+		return overlays[i].String() < overlays[j].String()
+	})
+	return overlays
+}
+
+// merge all overlays into the rendered template, the config is returned unchanged without overlays.
+// The template is merged on its node tree, so comments like the headers of routes and the style of values are kept.
+func (c *Controller) mergeOverlays(config []byte) ([]byte, []Conflict, error) {
+	overlays := c.readOverlays()
+	if len(overlays) == 0 {
+		return config, nil, nil
+	}
+
+	var doc yaml.Node
+	err := yaml.Unmarshal(config, &doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse rendered template: %s", err)
+	}
+	if len(doc.Content) == 0 || resolve(doc.Content[0]).Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("rendered template is not a mapping")
+	}
+	merged := resolve(doc.Content[0])
+	owners := make(map[string]string)
+	var conflicts []Conflict
+	for _, o := range overlays {
+		var content yaml.Node
+		err = yaml.Unmarshal([]byte(o.Content), &content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse overlay %s: %s", o, err)
+		}
+		if len(content.Content) == 0 {
+			continue
+		}
+		if resolve(content.Content[0]).Kind != yaml.MappingNode {
+			return nil, nil, fmt.Errorf("overlay %s is not a mapping", o)
+		}
+		mergeNodes(merged, resolve(content.Content[0]), "", o.String(), owners, &conflicts)
+	}
+
+	var result bytes.Buffer
+	encoder := yaml.NewEncoder(&result)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.Bytes(), conflicts, nil
+}
+
+// merge the mapping node overlay into base, owners remembers which overlay set a field last
+func mergeNodes(base *yaml.Node, overlay *yaml.Node, path string, origin string, owners map[string]string, conflicts *[]Conflict) {
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		key, value := overlay.Content[i], resolve(overlay.Content[i+1])
+		itemPath := path + "." + key.Value
+		index := -1
+		for j := 0; j+1 < len(base.Content); j += 2 {
+			if base.Content[j].Value == key.Value {
+				index = j + 1
+			}
+		}
+
+		if value.Kind == yaml.MappingNode {
+			if index < 0 || resolve(base.Content[index]).Kind != yaml.MappingNode {
+				child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				if index >= 0 {
+					base.Content[index] = child
+				} else {
+					base.Content = append(base.Content, expand(key), child)
+				}
+				mergeNodes(child, value, itemPath, origin, owners, conflicts)
+			} else {
+				mergeNodes(resolve(base.Content[index]), value, itemPath, origin, owners, conflicts)
+			}
+			continue
+		}
+
+		if owner, ok := owners[itemPath]; ok && owner != origin && index >= 0 && !equalNodes(base.Content[index], value) {
+			*conflicts = append(*conflicts, Conflict{Path: itemPath[1:], Winner: origin, Loser: owner})
+		}
+		owners[itemPath] = origin
+		if index >= 0 {
+			base.Content[index] = withComments(expand(value), base.Content[index])
+		} else {
+			base.Content = append(base.Content, expand(key), expand(value))
+		}
+	}
+}
+
+// the node an alias points to, any other node itself
+func resolve(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		return node.Alias
+	}
+	return node
+}
+
+// copy of a node with all aliases replaced by what they point to, so it can be moved into another document
+func expand(node *yaml.Node) *yaml.Node {
+	n := *resolve(node)
+	n.Anchor = ""
+	n.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range resolve(node).Content {
+		n.Content[i] = expand(child)
+	}
+	return &n
+}
+
+// keep the comments of a replaced node, unless the new one has its own
+func withComments(node *yaml.Node, replaced *yaml.Node) *yaml.Node {
+	if node.HeadComment == "" && node.LineComment == "" && node.FootComment == "" {
+		node.HeadComment = replaced.HeadComment
+		node.LineComment = replaced.LineComment
+		node.FootComment = replaced.FootComment
+	}
+	return node
+}
+
+// do two nodes hold the same values, whatever their style and comments
+func equalNodes(a *yaml.Node, b *yaml.Node) bool {
+	var x, y interface{}
+	if a.Decode(&x) != nil || b.Decode(&y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// remember the conflicts of the last built config
+func (c *Controller) setConflicts(conflicts []Conflict) {
+	for _, conflict := range conflicts {
+		//nolint:errcheck
+		level.Warn(c.logger).Log(
+			"msg", "Conflicting overlays, the one with higher precedence wins",
+			"path", conflict.Path,
+			"winner", conflict.Winner,
+			"loser", conflict.Loser,
+		)
+	}
+	overlayConflicts.WithLabelValues(c.a.ID).Set(float64(len(conflicts)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conflicts = conflicts
+}
+
+// Overlays lists the active overlays in the order they are merged together with the conflicts between them
+func (c *Controller) Overlays() ([]Overlay, []Conflict) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.readOverlays(), c.conflicts
+}
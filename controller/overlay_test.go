@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// a signed overlay configmap with the content of a file in testdata/overlay
+func overlayConfigMap(t *testing.T, namespace string, name string, precedence string) *v1.ConfigMap {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "overlay", name+".yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed(configMap(namespace, name, map[string]string{name + ".yaml": string(content)},
+		"alertmanager.net/overlay", "true", "alertmanager.net/precedence", precedence))
+}
+
+func TestMergeOverlays(t *testing.T) {
+	s := newTestSetup(t)
+	defer s.close()
+	s.c.Create(overlayConfigMap(t, "monitoring", "smtp", "0"))
+	s.c.Create(overlayConfigMap(t, "sre", "timeout", "10"))
+	base, err := ioutil.ReadFile(filepath.Join("testdata", "overlay", "base.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, conflicts, err := s.c.mergeOverlays(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "overlay", "merged.golden")
+	if *update {
+		if err = ioutil.WriteFile(golden, merged, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(merged) != string(want) {
+		t.Errorf("merged config differs from %s:\n%s", golden, merged)
+	}
+	if len(conflicts) != 1 || conflicts[0] != (Conflict{Path: "global.smtp_from", Winner: "sre/timeout/timeout.yaml", Loser: "monitoring/smtp/smtp.yaml"}) {
+		t.Errorf("conflicts = %v, want smtp_from won by sre", conflicts)
+	}
+}
+
+func TestMergeOverlaysErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		base    string
+		overlay string
+		err     string
+	}{
+		{"template is no mapping", "- a\n", "global: {}\n", "rendered template is not a mapping"},
+		{"overlay is no mapping", "global: {}\n", "- a\n", "overlay team-a/o/o.yaml is not a mapping"},
+		{"overlay can not be parsed", "global: {}\n", "global: [\n", "failed to parse overlay team-a/o/o.yaml"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetup(t)
+			defer s.close()
+			s.writeFile("config/"+configDir(overlayConst)+"/team-a-o-o.yaml",
+				s.c.overlayFile(configMap("team-a", "o", nil), "o.yaml", tc.overlay))
+			_, _, err := s.c.mergeOverlays([]byte(tc.base))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error = %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
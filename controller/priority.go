@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	v1 "k8s.io/api/core/v1"
+)
+
+// first line of a route fragment, which keeps its priority and origin in the rendered config
+var routeHeaderPattern = regexp.MustCompile(`^# priority: (-?\d+), configmap: (\S+), key: (\S+)\n`)
+
+// Route is a top-level route fragment at its position in the rendered config
+type Route struct {
+	Position  int    `json:"position"`
+	Priority  int    `json:"priority"`
+	Namespace string `json:"namespace,omitempty"`
+	ConfigMap string `json:"configmap,omitempty"`
+	Key       string `json:"key,omitempty"`
+	File      string `json:"file"`
+}
+
+// integer value of an annotation of a configmap, 0 if it has none
+func (c *Controller) intAnnotation(configmapObj *v1.ConfigMap, annotation string) int {
+	value, ok := configmapObj.Annotations[annotation]
+	if !ok {
+		return 0
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		//nolint:errcheck
+		level.Warn(c.logger).Log(
+			"msg", "Ignoring invalid "+annotation+": "+value,
+			"namespace", configmapObj.Namespace,
+			"name", configmapObj.Name,
+		)
+		return 0
+	}
+	return i
+}
+
+// comment in front of the routes of a key of a configmap
+func (c *Controller) routeHeader(configmapObj *v1.ConfigMap, key string) string {
+	return fmt.Sprintf("# priority: %d, configmap: %s/%s, key: %s\n",
+		c.intAnnotation(configmapObj, "alertmanager.net/priority"), configmapObj.Namespace, configmapObj.Name, key)
+}
+
+// read the route fragments in the order they are rendered: higher priority first,
+// ties are ordered by namespace, name and key of their configmap
+func (c *Controller) readRoutes() ([]Route, []string) {
+	files, err := filepath.Glob(c.a.ConfigPath + "/routes/*")
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to read routes", "err", err.Error())
+	}
+
+	routes := make([]Route, 0, len(files))
+	contents := make(map[string]string)
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to read routes file "+file, "err", err.Error())
+		}
+		r := Route{File: filepath.Base(file)}
+		if match := routeHeaderPattern.FindStringSubmatch(string(content)); match != nil {
+			r.Priority, _ = strconv.Atoi(match[1])
+			if origin := strings.SplitN(match[2], "/", 2); len(origin) == 2 {
+				r.Namespace, r.ConfigMap = origin[0], origin[1]
+			}
+			r.Key = match[3]
+		}
+		routes = append(routes, r)
+		contents[r.File] = string(content)
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Priority != routes[j].Priority {
+			return routes[i].Priority > routes[j].Priority
+		}
+		return routes[i].origin() < routes[j].origin()
+	})
+
+	ordered := make([]string, 0, len(routes))
+	for i := range routes {
+		routes[i].Position = i + 1
+		ordered = append(ordered, contents[routes[i].File])
+	}
+	return routes, ordered
+}
+
+// fragments written before they had a header are ordered by their file
+func (r Route) origin() string {
+	if r.ConfigMap == "" {
+		return r.File
+	}
+	return r.Namespace + "/" + r.ConfigMap + "/" + r.Key
+}
+
+// Routes lists the top-level route fragments in the order they are rendered
+func (c *Controller) Routes() []Route {
+	routes, _ := c.readRoutes()
+	return routes
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// a route configmap with the priority annotation, empty for none
+func priorityConfigMap(namespace string, name string, priority string) *v1.ConfigMap {
+	route := routeConfigMap(namespace, name, namespace+"-"+name)
+	if priority != "" {
+		route.Annotations["alertmanager.net/priority"] = priority
+	}
+	return route
+}
+
+func TestRoutePriority(t *testing.T) {
+	cases := []struct {
+		name       string
+		configMaps []*v1.ConfigMap
+		// receivers of the routes in the rendered order
+		order []string
+	}{
+		{
+			name: "without priority by namespace and name",
+			configMaps: []*v1.ConfigMap{
+				priorityConfigMap("team-b", "a", ""),
+				priorityConfigMap("team-a", "b", ""),
+				priorityConfigMap("team-a", "a", ""),
+			},
+			order: []string{"team-a-a", "team-a-b", "team-b-a"},
+		},
+		{
+			name: "higher priority first",
+			configMaps: []*v1.ConfigMap{
+				priorityConfigMap("team-a", "a", "-1"),
+				priorityConfigMap("team-b", "a", ""),
+				priorityConfigMap("team-c", "a", "10"),
+			},
+			order: []string{"team-c-a", "team-b-a", "team-a-a"},
+		},
+		{
+			name: "ties by namespace and name",
+			configMaps: []*v1.ConfigMap{
+				priorityConfigMap("team-b", "a", "5"),
+				priorityConfigMap("team-a", "b", "5"),
+				priorityConfigMap("team-a", "a", "5"),
+			},
+			order: []string{"team-a-a", "team-a-b", "team-b-a"},
+		},
+		{
+			name: "invalid priority counts as 0",
+			configMaps: []*v1.ConfigMap{
+				priorityConfigMap("team-a", "a", "high"),
+				priorityConfigMap("team-b", "a", "1"),
+			},
+			order: []string{"team-b-a", "team-a-a"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetup(t)
+			defer s.close()
+			for _, configmapObj := range tc.configMaps {
+				s.c.Create(receiverConfigMap(configmapObj.Namespace, configmapObj.Name+"-receiver", configmapObj.Namespace+"-"+configmapObj.Name))
+				s.c.Create(configmapObj)
+			}
+
+			var order []string
+			for i, r := range s.c.Routes() {
+				if r.Position != i+1 {
+					t.Errorf("position of %s = %d, want %d", r.File, r.Position, i+1)
+				}
+				order = append(order, r.Namespace+"-"+r.ConfigMap)
+			}
+			if !equalStrings(order, tc.order) {
+				t.Errorf("routes = %v, want %v", order, tc.order)
+			}
+
+			config := s.config()
+			last := -1
+			for _, receiver := range tc.order {
+				i := strings.Index(config, "receiver: "+receiver+"\n")
+				if i < 0 || i < last {
+					t.Errorf("route to %s is rendered out of order:\n%s", receiver, config)
+				}
+				last = i
+			}
+		})
+	}
+}
+
+func TestRouteHeader(t *testing.T) {
+	s := newTestSetup(t)
+	defer s.close()
+	s.c.Create(receiverConfigMap("monitoring", "receiver", "platform"))
+	route := priorityConfigMap("monitoring", "platform-routes", "10")
+	route.Data = map[string]string{"routes.yaml": "- receiver: platform\n"}
+	s.c.Create(route)
+	// the overlay merge must keep the headers of the routes
+	s.c.Create(overlayConfigMap(t, "monitoring", "smtp", "0"))
+
+	header := "# priority: 10, configmap: monitoring/platform-routes, key: routes.yaml\n"
+	if !strings.Contains(s.config(), header) {
+		t.Errorf("config does not contain %q:\n%s", header, s.config())
+	}
+	if !strings.Contains(s.config(), "smtp_smarthost") {
+		t.Errorf("overlay is not merged:\n%s", s.config())
+	}
+	routes := s.c.Routes()
+	want := Route{Position: 1, Priority: 10, Namespace: "monitoring", ConfigMap: "platform-routes", Key: "routes.yaml", File: "monitoring-platform-routes-routes.yaml"}
+	if len(routes) != 1 || routes[0] != want {
+		t.Errorf("routes = %+v, want %+v", routes, want)
+	}
+}
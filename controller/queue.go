@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"sync"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Queue hands the events of all informers to a single worker, so the controllers
+// never handle two events at the same time, whichever informer they come from.
+// A standby queue drops the events, the informer caches keep them for Lead.
+type Queue struct {
+	queue   workqueue.Interface
+	mu      sync.RWMutex
+	standby bool
+	// changes with every Standby and Lead, events of an earlier term are dropped
+	term int
+}
+
+// an event waiting in the queue, every event is a distinct item of the workqueue
+type event struct {
+	handle func()
+	term   int
+}
+
+// NewQueue creates an empty queue
+func NewQueue() *Queue {
+	return &Queue{queue: workqueue.New()}
+}
+
+// Add an event to the queue, it is handled after all events added before
+func (q *Queue) Add(handle func()) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	q.queue.Add(&event{handle: handle, term: q.term})
+}
+
+// Standby drops all events until Lead is called
+func (q *Queue) Standby() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.standby = true
+	q.term++
+}
+
+// Lead handles the events again, starting with handle,
+// events added before are dropped
+func (q *Queue) Lead(handle func()) {
+	q.mu.Lock()
+	q.standby = false
+	q.term++
+	q.mu.Unlock()
+	q.Add(handle)
+}
+
+// should the worker handle an event
+func (q *Queue) current(e *event) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return !q.standby && e.term == q.term
+}
+
+// Run handles the events one after another until the queue is shut down
+func (q *Queue) Run() {
+	for {
+		item, shutdown := q.queue.Get()
+		if shutdown {
+			return
+		}
+		if e := item.(*event); q.current(e) {
+			e.handle()
+		}
+		q.queue.Done(item)
+	}
+}
+
+// ShutDown stops the worker after it handled the events still in the queue
+func (q *Queue) ShutDown() {
+	q.queue.ShutDown()
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	"github.com/dbsystel/alertmanager-config-controller/sink"
+)
+
+// countingSink remembers how many configs were written at the same time at most
+type countingSink struct {
+	sink.Sink
+	mu       sync.Mutex
+	inflight int
+	max      int
+}
+
+func (s *countingSink) Write(config []byte) error {
+	s.mu.Lock()
+	s.inflight++
+	if s.inflight > s.max {
+		s.max = s.inflight
+	}
+	s.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	err := s.Sink.Write(config)
+	s.mu.Lock()
+	s.inflight--
+	s.mu.Unlock()
+	return err
+}
+
+func (s *countingSink) maxInflight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.max
+}
+
+func TestQueueSerializesNamespaces(t *testing.T) {
+	counting := &countingSink{}
+	s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+		counting.Sink = a.Sink
+		a.Sink = counting
+	})
+	defer s.close()
+	queue := NewQueue()
+	go queue.Run()
+	defer queue.ShutDown()
+	d := &Dispatcher{Controllers: []*Controller{s.c}, Queue: queue}
+
+	// two namespaces feed the dispatcher at the same time, like two informers
+	namespaces := []string{"team-a", "team-b"}
+	const perNamespace = 10
+	var fed sync.WaitGroup
+	for _, namespace := range namespaces {
+		fed.Add(1)
+		go func(namespace string) {
+			defer fed.Done()
+			for i := 0; i < perNamespace; i++ {
+				name := fmt.Sprintf("r%d", i)
+				d.Create(receiverConfigMap(namespace, name, namespace+"-"+name))
+			}
+			d.Update(receiverConfigMap(namespace, "r0", namespace+"-r0"), receiverConfigMap(namespace, "r0", namespace+"-updated"))
+			d.Delete(receiverConfigMap(namespace, "r1", namespace+"-r1"))
+		}(namespace)
+	}
+	fed.Wait()
+	done := make(chan struct{})
+	queue.Add(func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("queue did not handle the events")
+	}
+
+	if max := counting.maxInflight(); max != 1 {
+		t.Errorf("%d configs were written at the same time, want 1", max)
+	}
+	config := s.config()
+	for _, namespace := range namespaces {
+		for i := 0; i < perNamespace; i++ {
+			receiver := fmt.Sprintf("- name: %s-r%d\n", namespace, i)
+			if want := i != 0 && i != 1; strings.Contains(config, receiver) != want {
+				t.Errorf("config contains %q: %t, want %t", receiver, !want, want)
+			}
+		}
+		if !strings.Contains(config, "- name: "+namespace+"-updated\n") {
+			t.Errorf("config misses the updated receiver of %s", namespace)
+		}
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	queue := NewQueue()
+	var handled []int
+	for i := 0; i < 5; i++ {
+		i := i
+		queue.Add(func() { handled = append(handled, i) })
+	}
+	// equal events are not merged
+	same := func() { handled = append(handled, -1) }
+	queue.Add(same)
+	queue.Add(same)
+	queue.ShutDown()
+	queue.Run()
+
+	if want := []int{0, 1, 2, 3, 4, -1, -1}; fmt.Sprint(handled) != fmt.Sprint(want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+}
+
+func TestQueueStandby(t *testing.T) {
+	queue := NewQueue()
+	var handled []string
+	add := func(name string) {
+		queue.Add(func() { handled = append(handled, name) })
+	}
+	queue.Standby()
+	add("dropped")
+	queue.Lead(func() { handled = append(handled, "lead") })
+	add("handled")
+	queue.Add(queue.Standby)
+	add("dropped again")
+	queue.ShutDown()
+	queue.Run()
+
+	if want := []string{"lead", "handled"}; fmt.Sprint(handled) != fmt.Sprint(want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+}
@@ -0,0 +1,265 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/dbsystel/alertmanager-config-controller/policy"
+	"github.com/go-kit/kit/log/level"
+	v1 "k8s.io/api/core/v1"
+)
+
+// configmap whose fragments count against the quotas
+type quotaEntry struct {
+	// the configmap as it was received, to create it again
+	received *v1.ConfigMap
+	// the admitted configmap, whose fragments are counted
+	admitted   *v1.ConfigMap
+	configType string
+	// fragments and bytes of the admitted configmap
+	usage policy.Usage
+	// quarantined because it exceeded a quota
+	quarantined bool
+	// kept out of the config for another reason, e.g. in backup storage until its receiver exists
+	inactive bool
+}
+
+// does the configmap count against the quotas
+func (e *quotaEntry) counted() bool {
+	return !e.quarantined && !e.inactive
+}
+
+func quotaKey(configmapObj *v1.ConfigMap) string {
+	return configmapObj.Namespace + "/" + configmapObj.Name
+}
+
+// priority of a configmap for the quotas, invalid priorities are reported with its routes
+func quotaPriority(configmapObj *v1.ConfigMap) int {
+	priority, _ := strconv.Atoi(configmapObj.Annotations["alertmanager.net/priority"])
+	return priority
+}
+
+// configmaps get the quotas in a fixed order, independent of the order of their events:
+// higher priority first, ties are ordered by namespace and name
+func ranksBefore(a *v1.ConfigMap, b *v1.ConfigMap) bool {
+	if quotaPriority(a) != quotaPriority(b) {
+		return quotaPriority(a) > quotaPriority(b)
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+// fragments and bytes of a configmap
+func partsUsage(configmapObj *v1.ConfigMap, configType string) policy.Usage {
+	var usage policy.Usage
+	for _, p := range parts(configmapObj, configType) {
+		usage.Fragments++
+		usage.Bytes += len(p.content)
+	}
+	return usage
+}
+
+func addUsage(a policy.Usage, b policy.Usage) policy.Usage {
+	return policy.Usage{Fragments: a.Fragments + b.Fragments, Bytes: a.Bytes + b.Bytes}
+}
+
+// count the fragments of an admitted configmap against the quotas
+func (c *Controller) trackQuota(received *v1.ConfigMap, admitted *v1.ConfigMap, configType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.quotaEntries[quotaKey(admitted)] = &quotaEntry{
+		received:   received.DeepCopy(),
+		admitted:   admitted.DeepCopy(),
+		configType: configType,
+		usage:      partsUsage(admitted, configType),
+	}
+}
+
+// stop counting a configmap, which was deleted or replaced
+func (c *Controller) untrackQuota(configmapObj *v1.ConfigMap) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.quotaEntries, quotaKey(configmapObj))
+}
+
+// count a configmap again once its fragments are in the config, or stop counting it while they are not
+func (c *Controller) setQuotaInactive(key string, inactive bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.quotaEntries[key]; ok {
+		e.inactive = inactive
+	}
+}
+
+// like setQuotaInactive for the configmap of a fragment file
+func (c *Controller) setFragmentQuotaInactive(dir string, filename string, inactive bool) {
+	c.mu.RLock()
+	s, ok := c.sources[dir+"/"+filename]
+	c.mu.RUnlock()
+	if ok {
+		c.setQuotaInactive(s.namespace+"/"+s.name, inactive)
+	}
+}
+
+// a copy of a tracked configmap
+func (c *Controller) quotaEntry(key string) (quotaEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.quotaEntries[key]
+	if !ok {
+		return quotaEntry{}, false
+	}
+	return *e, true
+}
+
+// copies of the tracked configmaps in the order they get the quotas
+func (c *Controller) rankedQuotaEntries() []quotaEntry {
+	c.mu.RLock()
+	entries := make([]quotaEntry, 0, len(c.quotaEntries))
+	for _, e := range c.quotaEntries {
+		entries = append(entries, *e)
+	}
+	c.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return ranksBefore(entries[i].admitted, entries[j].admitted)
+	})
+	return entries
+}
+
+// check the quotas as if the fragments of the configmap were added,
+// only the counted configmaps ranked before it are taken into account
+func (c *Controller) checkQuota(configmapObj *v1.ConfigMap, configType string) error {
+	if c.a.Policy == nil || c.a.Policy.Quotas == nil {
+		return nil
+	}
+	var usage, global policy.Usage
+	c.mu.RLock()
+	for key, e := range c.quotaEntries {
+		// the fragments of the configmap itself are replaced
+		if key == quotaKey(configmapObj) || !e.counted() || !ranksBefore(e.admitted, configmapObj) {
+			continue
+		}
+		global = addUsage(global, e.usage)
+		if e.admitted.Namespace == configmapObj.Namespace {
+			usage = addUsage(usage, e.usage)
+		}
+	}
+	c.mu.RUnlock()
+	own := partsUsage(configmapObj, configType)
+	return c.a.Policy.CheckQuota(configmapObj.Namespace, addUsage(usage, own), addUsage(global, own))
+}
+
+// quarantine a configmap exceeding a quota, it is tried again when the usage shrinks
+func (c *Controller) quarantineQuota(configmapObj *v1.ConfigMap, configType string, err error) {
+	c.mu.Lock()
+	if e, ok := c.quotaEntries[quotaKey(configmapObj)]; ok {
+		e.quarantined = true
+	}
+	c.mu.Unlock()
+	c.quarantineConfigMap(configmapObj, configType, "quota", "quota exceeded: "+err.Error())
+}
+
+// usage of the configmaps admitted so far in one pass over the ranked configmaps
+type quotaUsage struct {
+	namespaces map[string]policy.Usage
+	global     policy.Usage
+}
+
+func (u *quotaUsage) add(e quotaEntry) {
+	u.namespaces[e.admitted.Namespace] = addUsage(u.namespaces[e.admitted.Namespace], e.usage)
+	u.global = addUsage(u.global, e.usage)
+}
+
+// check the quotas as if the configmap was added to the usage
+func (c *Controller) checkQuotaUsage(u *quotaUsage, e quotaEntry) error {
+	namespace := e.admitted.Namespace
+	return c.a.Policy.CheckQuota(namespace, addUsage(u.namespaces[namespace], e.usage), addUsage(u.global, e.usage))
+}
+
+// quarantine the counted configmaps, which are pushed out of the quotas by configmaps ranked before them,
+// the config has to be built afterwards
+func (c *Controller) enforceQuotas() {
+	if c.a.Policy == nil || c.a.Policy.Quotas == nil {
+		return
+	}
+	u := &quotaUsage{namespaces: make(map[string]policy.Usage)}
+	for _, e := range c.rankedQuotaEntries() {
+		if !e.counted() {
+			continue
+		}
+		if err := c.checkQuotaUsage(u, e); err != nil {
+			c.deleteConfig(e.admitted)
+			c.quarantineQuota(e.admitted, e.configType, err)
+			continue
+		}
+		u.add(e)
+	}
+}
+
+// create the configmaps quarantined by a quota again, which fit into the quotas now
+func (c *Controller) retryQuotas() {
+	if c.a.Policy == nil || c.a.Policy.Quotas == nil {
+		return
+	}
+	u := &quotaUsage{namespaces: make(map[string]policy.Usage)}
+	for _, e := range c.rankedQuotaEntries() {
+		key := quotaKey(e.admitted)
+		// a retry before may have changed the configmap
+		e, ok := c.quotaEntry(key)
+		if !ok {
+			continue
+		}
+		if e.counted() {
+			u.add(e)
+			continue
+		}
+		if !e.quarantined || c.checkQuotaUsage(u, e) != nil {
+			continue
+		}
+		//nolint:errcheck
+		level.Info(c.logger).Log(
+			"msg", "Retrying "+e.configType+" within the quotas",
+			"namespace", e.admitted.Namespace,
+			"name", e.admitted.Name,
+		)
+		c.deleteBackupFile(e.admitted, e.configType)
+		c.Create(e.received)
+		if e, ok = c.quotaEntry(key); ok && e.counted() {
+			u.add(e)
+		}
+	}
+}
+
+// keep all fragments of a configmap out of the config until it changes
+func (c *Controller) quarantineConfigMap(configmapObj *v1.ConfigMap, configType string, cause string, reason string) {
+	//nolint:errcheck
+	level.Warn(c.logger).Log(
+		"msg", "Quarantining "+configType,
+		"namespace", configmapObj.Namespace,
+		"name", configmapObj.Name,
+		"reason", reason,
+	)
+	rejectedConfigMaps.WithLabelValues(configType, cause).Inc()
+
+	c.setQuotaInactive(quotaKey(configmapObj), true)
+	c.trackFragments(configmapObj, configType, reason)
+	for _, p := range parts(configmapObj, configType) {
+		path := c.a.ConfigPath + "/quarantine-" + configDir(p.configType) + "/"
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			err = os.MkdirAll(path, 0766)
+			if err != nil {
+				//nolint:errcheck
+				level.Error(c.logger).Log("msg", "Failed to create quarantine directory", "err", err.Error())
+			}
+		}
+		err := ioutil.WriteFile(path+p.filename, []byte(p.content), 0644)
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to quarantine "+p.configType+": "+p.key, "err", err.Error())
+		}
+	}
+}
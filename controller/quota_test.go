@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	"github.com/dbsystel/alertmanager-config-controller/policy"
+)
+
+func TestQuota(t *testing.T) {
+	cases := []struct {
+		name string
+		run  func(s *testSetup)
+		// files in receivers and quarantine-receivers after the run
+		active      []string
+		quarantined []string
+	}{
+		{
+			name: "configmap exceeding the quota is quarantined",
+			run: func(s *testSetup) {
+				s.c.Create(receiverConfigMap("team-a", "a", "a"))
+				s.c.Create(receiverConfigMap("team-a", "b", "b"))
+				s.c.Create(receiverConfigMap("team-a", "c", "c"))
+			},
+			active:      []string{"team-a-a-receiver.yaml", "team-a-b-receiver.yaml"},
+			quarantined: []string{"team-a-c-receiver.yaml"},
+		},
+		{
+			name: "admission does not depend on the order of the events",
+			run: func(s *testSetup) {
+				s.c.Create(receiverConfigMap("team-a", "c", "c"))
+				s.c.Create(receiverConfigMap("team-a", "b", "b"))
+				s.c.Create(receiverConfigMap("team-a", "a", "a"))
+			},
+			active:      []string{"team-a-a-receiver.yaml", "team-a-b-receiver.yaml"},
+			quarantined: []string{"team-a-c-receiver.yaml"},
+		},
+		{
+			name: "higher priority is admitted first",
+			run: func(s *testSetup) {
+				s.c.Create(receiverConfigMap("team-a", "a", "a"))
+				s.c.Create(receiverConfigMap("team-a", "b", "b"))
+				c := receiverConfigMap("team-a", "c", "c")
+				c.Annotations["alertmanager.net/priority"] = "10"
+				s.c.Create(c)
+			},
+			active:      []string{"team-a-a-receiver.yaml", "team-a-c-receiver.yaml"},
+			quarantined: []string{"team-a-b-receiver.yaml"},
+		},
+		{
+			name: "other namespaces have their own quota",
+			run: func(s *testSetup) {
+				s.c.Create(receiverConfigMap("team-a", "a", "a"))
+				s.c.Create(receiverConfigMap("team-a", "b", "b"))
+				s.c.Create(receiverConfigMap("team-b", "a", "team-b-a"))
+			},
+			active:      []string{"team-a-a-receiver.yaml", "team-a-b-receiver.yaml", "team-b-a-receiver.yaml"},
+			quarantined: []string{},
+		},
+		{
+			name: "quarantined configmap is retried after a delete",
+			run: func(s *testSetup) {
+				s.c.Create(receiverConfigMap("team-a", "a", "a"))
+				s.c.Create(receiverConfigMap("team-a", "b", "b"))
+				s.c.Create(receiverConfigMap("team-a", "c", "c"))
+				s.c.Delete(receiverConfigMap("team-a", "a", "a"))
+			},
+			active:      []string{"team-a-b-receiver.yaml", "team-a-c-receiver.yaml"},
+			quarantined: []string{},
+		},
+		{
+			name: "quarantined configmap is retried after an update shrinks the usage",
+			run: func(s *testSetup) {
+				s.c.Create(receiverConfigMap("team-a", "a", "a"))
+				s.c.Create(receiverConfigMap("team-a", "b", "b"))
+				s.c.Create(receiverConfigMap("team-a", "c", "c"))
+				old := receiverConfigMap("team-a", "a", "a")
+				route := routeConfigMap("team-a", "a", "a")
+				route.Annotations["alertmanager.net/route"] = "false"
+				s.c.Update(old, route)
+			},
+			active:      []string{"team-a-b-receiver.yaml", "team-a-c-receiver.yaml"},
+			quarantined: []string{},
+		},
+		{
+			name: "quarantined configmaps are retried in their order",
+			run: func(s *testSetup) {
+				s.c.Create(receiverConfigMap("team-a", "a", "a"))
+				s.c.Create(receiverConfigMap("team-a", "b", "b"))
+				s.c.Create(receiverConfigMap("team-a", "d", "d"))
+				s.c.Create(receiverConfigMap("team-a", "c", "c"))
+				s.c.Delete(receiverConfigMap("team-a", "a", "a"))
+			},
+			active:      []string{"team-a-b-receiver.yaml", "team-a-c-receiver.yaml"},
+			quarantined: []string{"team-a-d-receiver.yaml"},
+		},
+		{
+			name: "growing update pushes out a configmap ranked after it",
+			run: func(s *testSetup) {
+				s.c.Create(receiverConfigMap("team-a", "a", "a"))
+				s.c.Create(receiverConfigMap("team-a", "b", "b"))
+				s.c.Update(receiverConfigMap("team-a", "a", "a"), configMap("team-a", "a", map[string]string{
+					"one.yaml": "- name: one\n",
+					"two.yaml": "- name: two\n",
+				}, "alertmanager.net/receiver", "true"))
+			},
+			active:      []string{"team-a-a-one.yaml", "team-a-a-two.yaml"},
+			quarantined: []string{"team-a-b-receiver.yaml"},
+		},
+		{
+			name: "backup fragments are not counted",
+			run: func(s *testSetup) {
+				// the route is kept in backup storage until its receiver exists
+				s.c.Create(routeConfigMap("team-a", "a", "missing"))
+				s.c.Create(receiverConfigMap("team-a", "b", "b"))
+				s.c.Create(receiverConfigMap("team-a", "c", "c"))
+			},
+			active:      []string{"team-a-b-receiver.yaml", "team-a-c-receiver.yaml"},
+			quarantined: []string{},
+		},
+		{
+			name: "backup fragments count once they are active",
+			run: func(s *testSetup) {
+				s.c.Create(routeConfigMap("team-a", "a", "b"))
+				s.c.Create(receiverConfigMap("team-a", "c", "c"))
+				s.c.Create(receiverConfigMap("team-a", "b", "b"))
+			},
+			active:      []string{"team-a-b-receiver.yaml"},
+			quarantined: []string{"team-a-c-receiver.yaml"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+				a.Policy = &policy.Policy{Quotas: &policy.Quotas{Default: &policy.Quota{MaxFragments: 2}}}
+			})
+			defer s.close()
+			tc.run(s)
+
+			if got := s.files("receivers"); !equalStrings(got, tc.active) {
+				t.Errorf("receivers = %v, want %v", got, tc.active)
+			}
+			if got := s.files("quarantine-receivers"); !equalStrings(got, tc.quarantined) {
+				t.Errorf("quarantined receivers = %v, want %v", got, tc.quarantined)
+			}
+			config := s.config()
+			for _, f := range tc.quarantined {
+				receiver := strings.TrimSuffix(strings.TrimPrefix(f, "team-a-"), "-receiver.yaml")
+				if strings.Contains(config, "http://"+receiver+"\n") {
+					t.Errorf("config contains quarantined receiver %s:\n%s", receiver, config)
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaReason(t *testing.T) {
+	s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+		a.Policy = &policy.Policy{Quotas: &policy.Quotas{Default: &policy.Quota{MaxFragments: 1}}}
+	})
+	defer s.close()
+	s.c.Create(receiverConfigMap("team-a", "b", "b"))
+	s.c.Create(receiverConfigMap("team-a", "a", "a"))
+
+	for _, f := range s.c.Fragments() {
+		if f.ConfigMap != "b" {
+			continue
+		}
+		want := "quota exceeded: namespace team-a would have 2 fragments, the limit is 1"
+		if f.State != stateQuarantined || f.Reason != want {
+			t.Errorf("fragment %s is %s with reason %q, want quarantined with %q", f.File, f.State, f.Reason, want)
+		}
+	}
+}
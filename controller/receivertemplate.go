@@ -0,0 +1,326 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var (
+	receiverTemplateConst = "receiver template"
+	receiverInstanceConst = "receiver instance"
+	receiverTemplateDir   = "receiver-templates"
+	receiverInstanceDir   = "receiver-instances"
+	// key of the receiver expanded from an instance
+	receiverInstanceKey = "receiver.yaml"
+)
+
+// receiverTemplate is a receiver with ${parameter} placeholders published by the platform admins
+type receiverTemplate struct {
+	Namespace string `yaml:"namespace"`
+	ConfigMap string `yaml:"configmap"`
+	Content   string `yaml:"content"`
+}
+
+func receiverTemplateName(configmapObj *v1.ConfigMap) (string, bool) {
+	name, ok := configmapObj.Annotations["alertmanager.net/receiver_template"]
+	return name, ok
+}
+
+func receiverInstanceTemplate(configmapObj *v1.ConfigMap) (string, bool) {
+	name, ok := configmapObj.Annotations["alertmanager.net/receiver_instance"]
+	return name, ok
+}
+
+// save the receiver template of a configmap and expand all instances of it again
+func (c *Controller) createReceiverTemplate(configmapObj *v1.ConfigMap) {
+	name, _ := receiverTemplateName(configmapObj)
+	if !c.matchesID(configmapObj) || !c.authorized(configmapObj) {
+		//nolint:errcheck
+		level.Debug(c.logger).Log("msg", "Skipping configmap:"+configmapObj.Name)
+		return
+	}
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		c.reject(configmapObj, receiverTemplateConst, "template", "invalid template name "+name+": "+strings.Join(errs, "; "))
+		return
+	}
+	if len(configmapObj.Data) != 1 {
+		c.reject(configmapObj, receiverTemplateConst, "template", "a receiver template needs exactly one key")
+		return
+	}
+	var content string
+	for _, v := range configmapObj.Data {
+		content = v
+	}
+
+	file := c.a.ConfigPath + "/" + receiverTemplateDir + "/" + name
+	if existing, ok := c.readReceiverTemplate(name); ok &&
+		(existing.Namespace != configmapObj.Namespace || existing.ConfigMap != configmapObj.Name) {
+		//nolint:errcheck
+		level.Warn(c.logger).Log(
+			"msg", "Replacing receiver template "+name+" of "+existing.Namespace+"/"+existing.ConfigMap,
+			"namespace", configmapObj.Namespace,
+			"name", configmapObj.Name,
+		)
+	}
+	t, _ := yaml.Marshal(receiverTemplate{Namespace: configmapObj.Namespace, ConfigMap: configmapObj.Name, Content: content})
+	if err := c.writeFile(file, t); err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to save receiver template "+name, "err", err.Error())
+		return
+	}
+	c.forgetRejection(configmapObj)
+	//nolint:errcheck
+	level.Info(c.logger).Log(
+		"msg", "Saved receiver template "+name,
+		"namespace", configmapObj.Namespace,
+		"name", configmapObj.Name,
+	)
+	c.refreshReceiverInstances(name)
+}
+
+// drop the receiver template of a configmap, instances keep their last expansion until they change
+func (c *Controller) deleteReceiverTemplate(configmapObj *v1.ConfigMap) {
+	name, _ := receiverTemplateName(configmapObj)
+	c.forgetRejection(configmapObj)
+	existing, ok := c.readReceiverTemplate(name)
+	if !ok || existing.Namespace != configmapObj.Namespace || existing.ConfigMap != configmapObj.Name {
+		return
+	}
+	err := os.Remove(c.a.ConfigPath + "/" + receiverTemplateDir + "/" + name)
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to delete receiver template "+name, "err", err.Error())
+		return
+	}
+	//nolint:errcheck
+	level.Info(c.logger).Log(
+		"msg", "Deleted receiver template "+name,
+		"namespace", configmapObj.Namespace,
+		"name", configmapObj.Name,
+	)
+}
+
+func (c *Controller) readReceiverTemplate(name string) (receiverTemplate, bool) {
+	var t receiverTemplate
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return t, false
+	}
+	content, err := ioutil.ReadFile(c.a.ConfigPath + "/" + receiverTemplateDir + "/" + name)
+	if err != nil {
+		return t, false
+	}
+	if err = yaml.Unmarshal(content, &t); err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to parse receiver template "+name, "err", err.Error())
+		return t, false
+	}
+	return t, true
+}
+
+// the receiver configmap an instance stands for, the receiver is expanded from the template with the
+// data of the instance as parameters
+func (c *Controller) expandReceiverInstance(configmapObj *v1.ConfigMap) (*v1.ConfigMap, error) {
+	name, _ := receiverInstanceTemplate(configmapObj)
+	t, ok := c.readReceiverTemplate(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown receiver template %s", name)
+	}
+
+	params := map[string]string{
+		"name":      configmapObj.Namespace + "-" + configmapObj.Name,
+		"namespace": configmapObj.Namespace,
+		"configmap": configmapObj.Name,
+	}
+	for k, v := range configmapObj.Data {
+		// the reserved parameters keep a receiver inside the namespace of its instance
+		if _, ok := params[k]; ok {
+			return nil, fmt.Errorf("parameter %s is reserved", k)
+		}
+		params[k] = v
+	}
+
+	// the placeholders are replaced in the scalars of the template, so a parameter can not add fields to the receiver
+	var doc yaml3.Node
+	if err := yaml3.Unmarshal([]byte(t.Content), &doc); err != nil {
+		return nil, fmt.Errorf("invalid receiver template %s: %s", name, err.Error())
+	}
+	missing := make(map[string]bool)
+	walkScalars(&doc, func(scalar *yaml3.Node) {
+		expanded := false
+		scalar.Value = variablePattern.ReplaceAllStringFunc(scalar.Value, func(placeholder string) string {
+			match := variablePattern.FindStringSubmatch(placeholder)
+			if match[1] != "" {
+				// escaped placeholders are resolved by the substitution of variables, if it is enabled
+				if c.a.Substitute {
+					return placeholder
+				}
+				return placeholder[1:]
+			}
+			if v, ok := params[match[2]]; ok {
+				expanded = true
+				return v
+			}
+			if _, ok := c.a.Vars[match[2]]; !ok || !c.a.Substitute {
+				missing[match[2]] = true
+			}
+			return placeholder
+		})
+		if expanded {
+			// a parameter is always a string, it is quoted if it looks like anything else
+			scalar.Tag = "!!str"
+		}
+	})
+	if len(missing) > 0 {
+		var names []string
+		for m := range missing {
+			names = append(names, m)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("missing parameters of receiver template %s: %s", name, strings.Join(names, ", "))
+	}
+
+	content := ""
+	if len(doc.Content) > 0 {
+		var out bytes.Buffer
+		encoder := yaml3.NewEncoder(&out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&doc); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+		content = out.String()
+	}
+
+	return receiverInstanceReceiver(configmapObj, content), nil
+}
+
+// call expand for every scalar of the node tree, aliases share the scalars of their anchor
+func walkScalars(node *yaml3.Node, expand func(*yaml3.Node)) {
+	switch node.Kind {
+	case yaml3.ScalarNode:
+		expand(node)
+	case yaml3.AliasNode:
+	default:
+		for _, n := range node.Content {
+			walkScalars(n, expand)
+		}
+	}
+}
+
+// a receiver configmap with the content of an expanded instance
+func receiverInstanceReceiver(configmapObj *v1.ConfigMap, content string) *v1.ConfigMap {
+	receiver := configmapObj.DeepCopy()
+	if receiver.Annotations == nil {
+		receiver.Annotations = make(map[string]string)
+	}
+	delete(receiver.Annotations, "alertmanager.net/receiver_instance")
+	receiver.Annotations["alertmanager.net/receiver"] = "true"
+	receiver.Data = map[string]string{receiverInstanceKey: content}
+	return receiver
+}
+
+// the receiver configmap of an instance or the configmap itself if it is none
+func (c *Controller) receiverInstance(configmapObj *v1.ConfigMap) (*v1.ConfigMap, error) {
+	if _, ok := receiverInstanceTemplate(configmapObj); !ok {
+		return configmapObj, nil
+	}
+	if !c.matchesID(configmapObj) {
+		c.deleteReceiverInstance(configmapObj)
+		return configmapObj, nil
+	}
+	c.saveReceiverInstance(configmapObj)
+	return c.expandReceiverInstance(configmapObj)
+}
+
+// remember an instance, so it can be expanded again when its template changes
+func (c *Controller) saveReceiverInstance(configmapObj *v1.ConfigMap) {
+	instance := v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   configmapObj.Namespace,
+			Name:        configmapObj.Name,
+			UID:         configmapObj.UID,
+			Labels:      configmapObj.Labels,
+			Annotations: configmapObj.Annotations,
+		},
+		Data: configmapObj.Data,
+	}
+	content, err := json.Marshal(instance)
+	if err == nil {
+		err = c.writeFile(c.receiverInstanceFile(configmapObj), content)
+	}
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log(
+			"msg", "Failed to save receiver instance",
+			"namespace", configmapObj.Namespace,
+			"name", configmapObj.Name,
+			"err", err.Error(),
+		)
+	}
+}
+
+func (c *Controller) deleteReceiverInstance(configmapObj *v1.ConfigMap) {
+	err := os.Remove(c.receiverInstanceFile(configmapObj))
+	if err != nil && !os.IsNotExist(err) {
+		//nolint:errcheck
+		level.Error(c.logger).Log(
+			"msg", "Failed to delete receiver instance",
+			"namespace", configmapObj.Namespace,
+			"name", configmapObj.Name,
+			"err", err.Error(),
+		)
+	}
+}
+
+func (c *Controller) receiverInstanceFile(configmapObj *v1.ConfigMap) string {
+	return c.a.ConfigPath + "/" + receiverInstanceDir + "/" + configmapObj.Namespace + "-" + configmapObj.Name + ".json"
+}
+
+// expand all instances of a template again
+func (c *Controller) refreshReceiverInstances(name string) {
+	files, err := filepath.Glob(c.a.ConfigPath + "/" + receiverInstanceDir + "/*.json")
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to read receiver instances", "err", err.Error())
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to read receiver instance "+file, "err", err.Error())
+			continue
+		}
+		var instance v1.ConfigMap
+		if err = json.Unmarshal(content, &instance); err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to parse receiver instance "+file, "err", err.Error())
+			continue
+		}
+		if template, _ := receiverInstanceTemplate(&instance); template == name {
+			c.Create(&instance)
+		}
+	}
+}
+
+// write a file, its directory is created if it does not exist
+func (c *Controller) writeFile(file string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0766); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, content, 0644)
+}
@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	v1 "k8s.io/api/core/v1"
+)
+
+// a signed receiver template with the name and content
+func receiverTemplateConfigMap(name string, content string) *v1.ConfigMap {
+	return signed(configMap("monitoring", "template-"+name, map[string]string{
+		"receiver.yaml": content,
+	}, "alertmanager.net/receiver_template", name))
+}
+
+// an instance of the template in team-a with the parameters
+func receiverInstanceConfigMap(name string, template string, params map[string]string) *v1.ConfigMap {
+	return configMap("team-a", name, params, "alertmanager.net/receiver_instance", template)
+}
+
+func TestExpandReceiverInstance(t *testing.T) {
+	webhook := "- name: ${name}\n  webhook_configs:\n  - url: ${url}\n"
+	cases := []struct {
+		name     string
+		template string
+		params   map[string]string
+		vars     map[string]string
+		want     string
+		// error contains, empty if the instance is expanded
+		err string
+	}{
+		{
+			name:     "parameters of the instance",
+			template: webhook,
+			params:   map[string]string{"url": "http://pager"},
+			want:     "- name: team-a-pager\n  webhook_configs:\n    - url: http://pager\n",
+		},
+		{
+			name:     "reserved parameters",
+			template: "- name: ${name}\n  webhook_configs:\n  - url: http://${namespace}/${configmap}\n",
+			want:     "- name: team-a-pager\n  webhook_configs:\n    - url: http://team-a/pager\n",
+		},
+		{
+			name:     "name is reserved",
+			template: webhook,
+			params:   map[string]string{"name": "team-b-pager", "url": "http://pager"},
+			err:      "parameter name is reserved",
+		},
+		{
+			name:     "namespace is reserved",
+			template: webhook,
+			params:   map[string]string{"namespace": "team-b", "url": "http://pager"},
+			err:      "parameter namespace is reserved",
+		},
+		{
+			name:     "configmap is reserved",
+			template: webhook,
+			params:   map[string]string{"configmap": "other", "url": "http://pager"},
+			err:      "parameter configmap is reserved",
+		},
+		{
+			name:     "parameter spanning several lines stays a string",
+			template: webhook,
+			params:   map[string]string{"url": "http://pager\n    send_resolved: false"},
+			want:     "- name: team-a-pager\n  webhook_configs:\n    - url: |-\n        http://pager\n            send_resolved: false\n",
+		},
+		{
+			name:     "parameter with yaml syntax stays a string",
+			template: webhook,
+			params:   map[string]string{"url": "#pager, {send_resolved: false}"},
+			want:     "- name: team-a-pager\n  webhook_configs:\n    - url: '#pager, {send_resolved: false}'\n",
+		},
+		{
+			name:     "parameter looking like another type stays a string",
+			template: "- name: ${name}\n  webhook_configs:\n  - url: http://pager\n    send_resolved: ${resolved}\n",
+			params:   map[string]string{"resolved": "false"},
+			want:     "- name: team-a-pager\n  webhook_configs:\n    - url: http://pager\n      send_resolved: \"false\"\n",
+		},
+		{
+			name:     "invalid template",
+			template: "- name: ${name}\n webhook_configs: []\n",
+			err:      "invalid receiver template webhook",
+		},
+		{
+			name:     "missing parameters",
+			template: "- name: ${name}\n  webhook_configs:\n  - url: ${url}/${path}\n",
+			err:      "missing parameters of receiver template webhook: path, url",
+		},
+		{
+			name:     "variables fill parameters the instance does not set",
+			template: webhook,
+			vars:     map[string]string{"url": "http://default"},
+			want:     "- name: team-a-pager\n  webhook_configs:\n    - url: ${url}\n",
+		},
+		{
+			name:     "escaped placeholders",
+			template: "- name: ${name}\n  webhook_configs:\n  - url: http://pager/$${url}\n",
+			want:     "- name: team-a-pager\n  webhook_configs:\n    - url: http://pager/${url}\n",
+		},
+		{
+			name:     "unknown template",
+			template: "",
+			err:      "unknown receiver template webhook",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+				a.Substitute = tc.vars != nil
+				a.Vars = tc.vars
+			})
+			defer s.close()
+			if tc.template != "" {
+				s.c.Create(receiverTemplateConfigMap("webhook", tc.template))
+			}
+
+			receiver, err := s.c.expandReceiverInstance(receiverInstanceConfigMap("pager", "webhook", tc.params))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("err = %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if got := receiver.Data[receiverInstanceKey]; got != tc.want {
+				t.Errorf("receiver = %q, want %q", got, tc.want)
+			}
+			if receiver.Annotations["alertmanager.net/receiver"] != "true" {
+				t.Errorf("annotations = %v, want a receiver", receiver.Annotations)
+			}
+		})
+	}
+}
+
+func TestReceiverTemplate(t *testing.T) {
+	webhook := "- name: ${name}\n  webhook_configs:\n  - url: ${url}\n"
+	cases := []struct {
+		name string
+		run  func(s *testSetup)
+		// config contains, entries starting with ! must not be contained
+		config []string
+		// namespace/configmap of the rejections
+		rejected []string
+	}{
+		{
+			name: "instance is expanded",
+			run: func(s *testSetup) {
+				s.c.Create(receiverTemplateConfigMap("webhook", webhook))
+				s.c.Create(receiverInstanceConfigMap("pager", "webhook", map[string]string{"url": "http://pager"}))
+			},
+			config:   []string{"- name: team-a-pager\n", "url: http://pager"},
+			rejected: []string{},
+		},
+		{
+			name: "unsigned template is ignored",
+			run: func(s *testSetup) {
+				template := receiverTemplateConfigMap("webhook", webhook)
+				delete(template.Annotations, "alertmanager.net/signature")
+				s.c.Create(template)
+				s.c.Create(receiverInstanceConfigMap("pager", "webhook", map[string]string{"url": "http://pager"}))
+			},
+			config:   []string{"!team-a-pager"},
+			rejected: []string{"team-a/pager"},
+		},
+		{
+			name: "instance setting a reserved parameter is rejected",
+			run: func(s *testSetup) {
+				s.c.Create(receiverTemplateConfigMap("webhook", webhook))
+				s.c.Create(receiverInstanceConfigMap("pager", "webhook", map[string]string{"name": "team-b-pager", "url": "http://pager"}))
+			},
+			config:   []string{"!team-b-pager", "!team-a-pager"},
+			rejected: []string{"team-a/pager"},
+		},
+		{
+			name: "instance is expanded when its template is created",
+			run: func(s *testSetup) {
+				s.c.Create(receiverInstanceConfigMap("pager", "webhook", map[string]string{"url": "http://pager"}))
+				s.c.Create(receiverTemplateConfigMap("webhook", webhook))
+			},
+			config:   []string{"- name: team-a-pager\n", "url: http://pager"},
+			rejected: []string{},
+		},
+		{
+			name: "instances are expanded again when their template changes",
+			run: func(s *testSetup) {
+				s.c.Create(receiverTemplateConfigMap("webhook", webhook))
+				s.c.Create(receiverInstanceConfigMap("pager", "webhook", map[string]string{"url": "http://pager"}))
+				s.c.Create(receiverTemplateConfigMap("webhook", webhook+"    send_resolved: false\n"))
+			},
+			config:   []string{"url: http://pager", "send_resolved: false"},
+			rejected: []string{},
+		},
+		{
+			name: "instances keep their expansion when their template is deleted",
+			run: func(s *testSetup) {
+				s.c.Create(receiverTemplateConfigMap("webhook", webhook))
+				s.c.Create(receiverInstanceConfigMap("pager", "webhook", map[string]string{"url": "http://pager"}))
+				s.c.Delete(receiverTemplateConfigMap("webhook", webhook))
+			},
+			config:   []string{"- name: team-a-pager\n", "url: http://pager"},
+			rejected: []string{},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetup(t)
+			defer s.close()
+			tc.run(s)
+
+			config := s.config()
+			for _, want := range tc.config {
+				if strings.HasPrefix(want, "!") {
+					if strings.Contains(config, want[1:]) {
+						t.Errorf("config contains %q:\n%s", want[1:], config)
+					}
+				} else if !strings.Contains(config, want) {
+					t.Errorf("config does not contain %q:\n%s", want, config)
+				}
+			}
+			var rejected []string
+			for _, r := range s.c.Rejections() {
+				rejected = append(rejected, r.Namespace+"/"+r.ConfigMap)
+			}
+			if rejected == nil {
+				rejected = []string{}
+			}
+			if !equalStrings(rejected, tc.rejected) {
+				t.Errorf("rejections = %v, want %v", s.c.Rejections(), tc.rejected)
+			}
+		})
+	}
+}
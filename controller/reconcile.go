@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-kit/kit/log/level"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// all storage the configmaps are written to, Reconcile fills it again from scratch
+func storageDirs() []string {
+	var dirs []string
+	for _, dir := range fragmentDirs {
+		dirs = append(dirs, dir, "backup-"+dir, "quarantine-"+dir, "staging-"+dir)
+	}
+	return append(dirs, "backup-"+bundleDir, receiverTemplateDir, receiverInstanceDir)
+}
+
+// Reconcile replaces the fragments on disk by those of the configmaps and namespaces in the informer caches,
+// which may have changed while this replica was standing by, and publishes the config once afterwards
+func (c *Controller) Reconcile(configMaps []interface{}, namespaces []interface{}) {
+	//nolint:errcheck
+	level.Info(c.logger).Log("msg", "Reconciling fragments with the informer cache", "configmaps", len(configMaps))
+	c.removeStorage()
+
+	c.mu.Lock()
+	c.sources = make(map[string]source)
+	c.rejections = make(map[string]Rejection)
+	c.conflicts = nil
+	c.teamReceivers = make(map[string]string)
+	c.quotaEntries = make(map[string]*quotaEntry)
+	c.mu.Unlock()
+	for _, obj := range namespaces {
+		if namespace, ok := obj.(*v1.Namespace); ok {
+			c.setTeamReceiver(namespace.Name, teamReceiver(namespace))
+		}
+	}
+
+	// receiver templates are needed to expand the instances, everything else in a stable order
+	var ordered []*v1.ConfigMap
+	for _, obj := range configMaps {
+		if configmapObj, ok := obj.(*v1.ConfigMap); ok {
+			ordered = append(ordered, configmapObj)
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		_, iTemplate := receiverTemplateName(ordered[i])
+		_, jTemplate := receiverTemplateName(ordered[j])
+		if iTemplate != jTemplate {
+			return iTemplate
+		}
+		if ordered[i].Namespace != ordered[j].Namespace {
+			return ordered[i].Namespace < ordered[j].Namespace
+		}
+		return ordered[i].Name < ordered[j].Name
+	})
+
+	// every configmap is checked against the config built so far, but only the last config is published
+	c.replaying = true
+	for _, configmapObj := range ordered {
+		c.Create(configmapObj)
+	}
+	c.replaying = false
+
+	trigger := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "controller", Name: "reconcile"}}
+	err := c.buildConfig()
+	if err == nil {
+		c.reload(trigger)
+	}
+}
+
+// Remove withdraws the fragments and the published config of a setup which is no longer configured
+func (c *Controller) Remove() {
+	//nolint:errcheck
+	level.Info(c.logger).Log("msg", "Removing fragments and config of a removed setup", "output", c.a.Sink.String())
+	c.removeStorage()
+	err := c.a.Sink.Remove()
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to remove config from "+c.a.Sink.String(), "err", err.Error())
+	}
+}
+
+func (c *Controller) removeStorage() {
+	for _, dir := range storageDirs() {
+		err := os.RemoveAll(filepath.Join(c.a.ConfigPath, dir))
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to remove "+dir, "err", err.Error())
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestReconcile(t *testing.T) {
+	cases := []struct {
+		name string
+		// state before the replica took over
+		before func(s *testSetup)
+		// the informer cache
+		configMaps []interface{}
+		namespaces []interface{}
+		// the published alertmanager.yml contains, or does not contain with a leading !
+		config []string
+		// files in receivers after the reconcile
+		receivers []string
+	}{
+		{
+			name: "configmaps deleted while standing by are removed",
+			before: func(s *testSetup) {
+				s.c.Create(receiverConfigMap("team-a", "kept", "kept"))
+				s.c.Create(receiverConfigMap("team-b", "deleted", "deleted"))
+			},
+			configMaps: []interface{}{receiverConfigMap("team-a", "kept", "kept")},
+			config:     []string{"http://kept", "!http://deleted"},
+			receivers:  []string{"team-a-kept-receiver.yaml"},
+		},
+		{
+			name: "configmaps changed while standing by are updated",
+			before: func(s *testSetup) {
+				s.c.Create(receiverConfigMap("team-a", "changed", "old"))
+			},
+			configMaps: []interface{}{receiverConfigMap("team-a", "changed", "new")},
+			config:     []string{"http://new", "!http://old"},
+			receivers:  []string{"team-a-changed-receiver.yaml"},
+		},
+		{
+			name: "stale files of a former term are removed",
+			before: func(s *testSetup) {
+				s.writeFile("config/receivers/team-c-stale-receiver.yaml", "- name: stale\n")
+				s.writeFile("config/backup-receivers/team-c-stale-receiver.yaml", "- name: stale\n")
+				s.writeFile("config/quarantine-routes/team-c-stale-route.yaml", "- receiver: stale\n")
+				s.writeFile("config/staging-routes/team-c-stale-route.yaml", "- receiver: stale\n")
+			},
+			configMaps: []interface{}{receiverConfigMap("team-a", "new", "new"), routeConfigMap("team-a", "route", "new")},
+			config:     []string{"http://new", "receiver: new", "!stale"},
+			receivers:  []string{"team-a-new-receiver.yaml"},
+		},
+		{
+			name: "receiver instances are expanded after their template",
+			configMaps: []interface{}{
+				configMap("team-a", "pager", map[string]string{"url": "http://pager"}, "alertmanager.net/receiver_instance", "webhook"),
+				signed(configMap("monitoring", "webhook", map[string]string{
+					"receiver.yaml": "- name: ${name}\n  webhook_configs:\n  - url: ${url}\n",
+				}, "alertmanager.net/receiver_template", "webhook")),
+			},
+			config:    []string{"- name: team-a-pager\n", "url: http://pager"},
+			receivers: []string{"team-a-pager-receiver.yaml"},
+		},
+		{
+			name:       "team receivers of the namespaces",
+			configMaps: []interface{}{receiverConfigMap("team-a", "oncall", "oncall")},
+			namespaces: []interface{}{namespace("team-a", "oncall"), namespace("team-b", "")},
+			config:     []string{"namespace: team-a", "!namespace: team-b"},
+			receivers:  []string{"team-a-oncall-receiver.yaml"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+				a.NamespaceRoutes = true
+			})
+			defer s.close()
+			if tc.before != nil {
+				tc.before(s)
+			}
+			s.mu.Lock()
+			reloads := s.reloads
+			s.mu.Unlock()
+
+			s.c.Reconcile(tc.configMaps, tc.namespaces)
+
+			config := s.config()
+			for _, c := range tc.config {
+				if strings.HasPrefix(c, "!") && strings.Contains(config, c[1:]) {
+					t.Errorf("config contains %q:\n%s", c[1:], config)
+				}
+				if !strings.HasPrefix(c, "!") && !strings.Contains(config, c) {
+					t.Errorf("config does not contain %q:\n%s", c, config)
+				}
+			}
+			if got := s.files("receivers"); !equalStrings(got, tc.receivers) {
+				t.Errorf("receivers = %v, want %v", got, tc.receivers)
+			}
+			for _, dir := range []string{"backup-receivers", "quarantine-routes", "staging-routes"} {
+				if got := s.files(dir); len(got) != 0 {
+					t.Errorf("%s = %v, want none", dir, got)
+				}
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.reloads-reloads != 1 {
+				t.Errorf("%d reloads, want a single one", s.reloads-reloads)
+			}
+			for _, f := range s.c.Fragments() {
+				if f.Namespace == "" {
+					t.Errorf("fragment %s has no source configmap", f.File)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileKeepsInvalidConfigMapsOut(t *testing.T) {
+	s := newTestSetup(t)
+	defer s.close()
+	s.c.Reconcile([]interface{}{
+		receiverConfigMap("team-a", "good", "good"),
+		configMap("team-b", "broken", map[string]string{"route.yaml": "receiver: [\n"}, "alertmanager.net/route", "true"),
+		&v1.Secret{},
+	}, nil)
+
+	config := s.config()
+	if !strings.Contains(config, "http://good") {
+		t.Errorf("config misses the valid receiver:\n%s", config)
+	}
+	if got := s.files("quarantine-routes"); !equalStrings(got, []string{"team-b-broken-route.yaml"}) {
+		t.Errorf("quarantined routes = %v, want the broken one", got)
+	}
+}
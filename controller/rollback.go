@@ -0,0 +1,344 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	v1 "k8s.io/api/core/v1"
+)
+
+var (
+	lastGoodDir      = "last-good"
+	fragmentManifest = "fragments"
+	templateSnapshot = "alertmanager.tmpl"
+	fragmentDirs     = []string{"routes", "receivers", "inhibit-rules", "overlays"}
+)
+
+// reload alertmanager and fall back to the last known-good config if it rejects the new one
+func (c *Controller) reload(configmapObj *v1.ConfigMap) {
+	if c.replaying {
+		return
+	}
+	if c.a.URL == nil {
+		//nolint:errcheck
+		level.Info(c.logger).Log("msg", "Succeeded: Published alertmanager.yml to "+c.a.Sink.String())
+		c.saveLastGoodConfig()
+		c.saveHistory(configmapObj.Namespace + "/" + configmapObj.Name)
+		return
+	}
+	status, err := c.a.Reload()
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log(
+			"msg", "Failed to reload alertmanager.yml",
+			"err", err.Error(),
+			"namespace", configmapObj.Namespace,
+			"name", configmapObj.Name,
+		)
+		// a status code means Alertmanager answered and refused the config
+		if status != 0 {
+			c.rollbackConfig(configmapObj, err.Error())
+		}
+		return
+	}
+	//nolint:errcheck
+	level.Info(c.logger).Log("msg", "Succeeded: Reloaded Alertmanager")
+	c.saveLastGoodConfig()
+	c.saveHistory(configmapObj.Namespace + "/" + configmapObj.Name)
+}
+
+// keep the loaded alertmanager.yml as new generation in the history
+func (c *Controller) saveHistory(trigger string) {
+	config, err := c.a.Sink.Read()
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to read alertmanager.yml for history", "err", err.Error())
+		return
+	}
+	generation, err := c.history.Save(config, trigger)
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to save alertmanager.yml to history", "err", err.Error())
+		return
+	}
+	if generation != nil {
+		//nolint:errcheck
+		level.Debug(c.logger).Log("msg", "Saved alertmanager.yml to history", "generation", generation.ID, "trigger", trigger)
+	}
+}
+
+// remember the currently loaded alertmanager.yml and the fragments it was built from
+func (c *Controller) saveLastGoodConfig() {
+	path := c.a.ConfigPath + "/" + lastGoodDir + "/"
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		err = os.MkdirAll(path, 0766)
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to create last known-good directory", "err", err.Error())
+			return
+		}
+	}
+
+	config, err := c.a.Sink.Read()
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to read alertmanager.yml from "+c.a.Sink.String(), "err", err.Error())
+		return
+	}
+	err = ioutil.WriteFile(path+"alertmanager.yml", config, 0644)
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to save last known-good alertmanager.yml", "err", err.Error())
+		return
+	}
+
+	template, err := ioutil.ReadFile(c.a.ConfigTemplate)
+	if err == nil {
+		err = ioutil.WriteFile(path+templateSnapshot, template, 0644)
+	}
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to save last known-good template", "err", err.Error())
+	}
+
+	manifest := ""
+	for _, dir := range fragmentDirs {
+		for name, hash := range c.hashFragments(dir) {
+			manifest = manifest + dir + "/" + name + " " + hash + "\n"
+		}
+	}
+	err = ioutil.WriteFile(path+fragmentManifest, []byte(manifest), 0644)
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to save last known-good fragments", "err", err.Error())
+	}
+}
+
+// restore the last known-good alertmanager.yml and quarantine every fragment added or changed since,
+// a changed template is restored first and the fragments are only quarantined if the config is still rejected.
+// Without a known-good config only the fragments of the configmap which triggered the reload are quarantined.
+func (c *Controller) rollbackConfig(configmapObj *v1.ConfigMap, reason string) {
+	if c.restoreTemplate() {
+		err := c.publish()
+		if err == nil {
+			//nolint:errcheck
+			level.Warn(c.logger).Log("msg", "Restored last known-good template, Alertmanager rejected the changed one")
+			return
+		}
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Config is still rejected with the last known-good template", "err", err.Error())
+	}
+
+	path := c.a.ConfigPath + "/" + lastGoodDir + "/"
+	known, hasManifest := c.readFragmentManifest(path + fragmentManifest)
+	bundles := make(map[string]bool)
+
+	for _, dir := range fragmentDirs {
+		for name, hash := range c.hashFragments(dir) {
+			if hasManifest && known[dir+"/"+name] == hash {
+				continue
+			}
+			if !hasManifest && !c.fromConfigMap(dir, name, configmapObj) {
+				continue
+			}
+			if bundle, ok := c.bundleOf(dir, name); ok {
+				bundles[bundle] = true
+			}
+			c.quarantineFile(dir, name, reason)
+		}
+	}
+
+	// a bundle is quarantined as a whole, so none of its fragments stays active without the others
+	for _, dir := range fragmentDirs {
+		for name := range c.hashFragments(dir) {
+			if bundle, ok := c.bundleOf(dir, name); ok && bundles[bundle] {
+				c.quarantineFile(dir, name, reason)
+			}
+		}
+	}
+
+	config, err := ioutil.ReadFile(path + "alertmanager.yml")
+	if err != nil {
+		// without a known-good config the output is rendered again from the fragments left,
+		// the rejected alertmanager.yml must not stay in place either way
+		//nolint:errcheck
+		level.Warn(c.logger).Log("msg", "No last known-good alertmanager.yml available, rendering it again", "err", err.Error())
+		err = c.publish()
+		if err == nil {
+			return
+		}
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to publish a config accepted by Alertmanager, removing it", "err", err.Error())
+		err = c.a.Sink.Remove()
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to remove alertmanager.yml from "+c.a.Sink.String(), "err", err.Error())
+		}
+		return
+	}
+	err = c.a.Sink.Write(config)
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to write alertmanager.yml to "+c.a.Sink.String(), "err", err.Error())
+		return
+	}
+
+	_, err = c.a.Reload()
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to reload last known-good alertmanager.yml", "err", err.Error())
+		return
+	}
+	//nolint:errcheck
+	level.Warn(c.logger).Log("msg", "Restored last known-good alertmanager.yml")
+}
+
+// build the config from the current fragments and reload it, it becomes the last known-good config if it is accepted
+func (c *Controller) publish() error {
+	err := c.buildConfig()
+	if err != nil {
+		return err
+	}
+	if c.a.URL != nil {
+		_, err = c.a.Reload()
+		if err != nil {
+			return err
+		}
+	}
+	c.saveLastGoodConfig()
+	return nil
+}
+
+// put back the template of the last known-good config, if it changed since
+func (c *Controller) restoreTemplate() bool {
+	snapshot, err := ioutil.ReadFile(c.a.ConfigPath + "/" + lastGoodDir + "/" + templateSnapshot)
+	if err != nil {
+		return false
+	}
+	current, err := ioutil.ReadFile(c.a.ConfigTemplate)
+	if err == nil && bytes.Equal(current, snapshot) {
+		return false
+	}
+	err = ioutil.WriteFile(c.a.ConfigTemplate, snapshot, 0644)
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to restore last known-good template", "err", err.Error())
+		return false
+	}
+	return true
+}
+
+// move a fragment out of the active config so it is not picked up again until its configmap changes
+func (c *Controller) quarantineFile(dir string, name string, reason string) {
+	path := c.a.ConfigPath + "/quarantine-" + dir + "/"
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		err = os.MkdirAll(path, 0766)
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to create quarantine directory", "err", err.Error())
+			return
+		}
+	}
+	//nolint:errcheck
+	level.Warn(c.logger).Log(
+		"msg", "Quarantining fragment rejected by Alertmanager",
+		"fragment", dir+"/"+name,
+	)
+	err := os.Rename(c.a.ConfigPath+"/"+dir+"/"+name, path+name)
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to quarantine fragment: "+dir+"/"+name, "err", err.Error())
+		return
+	}
+	c.setFragmentReason(dir, name, "rejected by Alertmanager: "+reason)
+	c.setFragmentQuotaInactive(dir, name, true)
+}
+
+// remove a fragment from quarantine storage
+func (c *Controller) deleteQuarantinedFile(filename string, configType string) {
+	path := c.a.ConfigPath + "/quarantine-" + configDir(configType) + "/" + filename
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return
+	}
+	//nolint:errcheck
+	level.Debug(c.logger).Log("msg", "Delete quarantined "+configType, "file", filename)
+	err := os.Remove(path)
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to delete quarantined "+configType+": "+filename, "err", err.Error())
+	}
+}
+
+// content hashes of all fragments in a storage directory
+func (c *Controller) hashFragments(dir string) map[string]string {
+	hashes := make(map[string]string)
+	files, err := filepath.Glob(c.a.ConfigPath + "/" + dir + "/*")
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to read "+dir, "err", err.Error())
+		return hashes
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to read "+dir+" file "+file, "err", err.Error())
+			continue
+		}
+		hashes[filepath.Base(file)] = hashContent(content)
+	}
+	return hashes
+}
+
+// read the fragments of the last known-good config, false if there is none
+func (c *Controller) readFragmentManifest(file string) (map[string]string, bool) {
+	known := make(map[string]string)
+	f, err := os.Open(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to read last known-good fragments", "err", err.Error())
+		}
+		return known, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			known[fields[0]] = fields[1]
+		}
+	}
+	return known, true
+}
+
+// storage directory of a config type
+func configDir(configType string) string {
+	switch configType {
+	case routeConst:
+		return "routes"
+	case receiverConst:
+		return "receivers"
+	case inhibitRuleConst:
+		return "inhibit-rules"
+	case bundleConst:
+		return bundleDir
+	case overlayConst:
+		return "overlays"
+	default:
+		return ""
+	}
+}
+
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRollbackConfig(t *testing.T) {
+	cases := []struct {
+		name string
+		run  func(s *testSetup)
+		// files in quarantine-receivers after the run
+		quarantined []string
+		// the published alertmanager.yml contains, or does not contain with a leading !
+		config []string
+		// the template after the run contains
+		template string
+	}{
+		{
+			name: "rejected receiver is quarantined and the last known-good config restored",
+			run: func(s *testSetup) {
+				s.c.Create(receiverConfigMap("team-a", "good", "good"))
+				s.rejectConfigs("http://bad")
+				s.c.Create(receiverConfigMap("team-b", "bad", "bad"))
+			},
+			quarantined: []string{"team-b-bad-receiver.yaml"},
+			config:      []string{"http://good", "!http://bad"},
+		},
+		{
+			name: "bundle is quarantined as a whole",
+			run: func(s *testSetup) {
+				s.c.Create(receiverConfigMap("team-a", "good", "good"))
+				s.rejectConfigs("http://bad")
+				s.c.Create(configMap("team-b", "bundle", map[string]string{
+					"receivers.yaml": "- name: bad\n  webhook_configs:\n  - url: http://bad\n",
+					"routes.yaml":    "- receiver: bad\n",
+				}, "alertmanager.net/bundle", "true"))
+			},
+			quarantined: []string{"team-b-bundle-receivers.yaml"},
+			config:      []string{"http://good", "!http://bad", "!receiver: bad"},
+		},
+		{
+			name: "changed template is restored before fragments are blamed",
+			run: func(s *testSetup) {
+				s.c.Create(receiverConfigMap("team-a", "good", "good"))
+				s.rejectConfigs("resolve_timeout: 1m")
+				s.writeFile("template/alertmanager.tmpl", strings.Replace(testTemplate, "5m", "1m", 1))
+				s.c.Create(receiverConfigMap("team-b", "new", "new"))
+			},
+			quarantined: []string{},
+			config:      []string{"http://good", "http://new", "resolve_timeout: 5m"},
+			template:    "resolve_timeout: 5m",
+		},
+		{
+			name: "without a known-good config the output is rendered again",
+			run: func(s *testSetup) {
+				s.rejectConfigs("http://bad")
+				s.c.Create(receiverConfigMap("team-b", "bad", "bad"))
+			},
+			quarantined: []string{"team-b-bad-receiver.yaml"},
+			config:      []string{"receivers:", "!http://bad"},
+		},
+		{
+			name: "without a known-good config only the triggering configmap is quarantined",
+			run: func(s *testSetup) {
+				// fragments of earlier runs, which were never reloaded
+				s.writeFile("config/receivers/team-a-good-receiver.yaml", "- name: good\n  webhook_configs:\n  - url: http://good\n")
+				s.writeFile("config/routes/team-a-route-route.yaml", "- receiver: good\n  continue: true\n")
+				s.rejectConfigs("http://bad")
+				s.c.Create(receiverConfigMap("team-b", "bad", "bad"))
+			},
+			quarantined: []string{"team-b-bad-receiver.yaml"},
+			config:      []string{"http://good", "receiver: good", "!http://bad"},
+		},
+		{
+			name: "without a known-good config a rejected output is removed",
+			run: func(s *testSetup) {
+				s.rejectConfigs("resolve_timeout")
+				s.c.Create(receiverConfigMap("team-b", "new", "new"))
+			},
+			quarantined: []string{"team-b-new-receiver.yaml"},
+			config:      []string{"!receivers:"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetup(t)
+			defer s.close()
+			tc.run(s)
+
+			if got := s.files("quarantine-receivers"); !equalStrings(got, tc.quarantined) {
+				t.Errorf("quarantined receivers = %v, want %v", got, tc.quarantined)
+			}
+			config := s.config()
+			for _, c := range tc.config {
+				if strings.HasPrefix(c, "!") && strings.Contains(config, c[1:]) {
+					t.Errorf("config contains %q:\n%s", c[1:], config)
+				}
+				if !strings.HasPrefix(c, "!") && !strings.Contains(config, c) {
+					t.Errorf("config does not contain %q:\n%s", c, config)
+				}
+			}
+			if tc.template != "" {
+				template, _ := s.readTemplate()
+				if !strings.Contains(template, tc.template) {
+					t.Errorf("template does not contain %q:\n%s", tc.template, template)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveLastGoodConfig(t *testing.T) {
+	s := newTestSetup(t)
+	defer s.close()
+	s.c.Create(receiverConfigMap("team-a", "good", "good"))
+
+	if s.readFile("last-good/alertmanager.yml") != s.config() {
+		t.Errorf("last known-good config differs from the published one")
+	}
+	if s.readFile("last-good/"+templateSnapshot) != testTemplate {
+		t.Errorf("last known-good template differs from the template")
+	}
+	manifest := s.readFile("last-good/" + fragmentManifest)
+	if !strings.HasPrefix(manifest, "receivers/team-a-good-receiver.yaml ") {
+		t.Errorf("manifest does not list the receiver:\n%s", manifest)
+	}
+}
+
+func TestRollbackReasonIsReported(t *testing.T) {
+	s := newTestSetup(t)
+	defer s.close()
+	s.c.Create(receiverConfigMap("team-a", "good", "good"))
+	s.rejectConfigs("http://bad")
+	s.c.Create(receiverConfigMap("team-b", "bad", "bad"))
+
+	for _, f := range s.c.Fragments() {
+		if f.Namespace != "team-b" {
+			continue
+		}
+		if f.State != stateQuarantined || !strings.HasPrefix(f.Reason, "rejected by Alertmanager: ") {
+			t.Errorf("fragment %s is %s with reason %q, want quarantined and rejected by Alertmanager", f.File, f.State, f.Reason)
+		}
+	}
+}
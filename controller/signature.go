@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/go-kit/kit/log/level"
+	v1 "k8s.io/api/core/v1"
+)
+
+// signed content of a configmap, the namespace and name keep a signature from being replayed in another configmap
+type signedConfigMap struct {
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	Data      map[string]string `json:"data"`
+}
+
+// Signature returns the hex encoded HMAC-SHA256 of the namespace, name and data of a configmap
+func Signature(key string, configmapObj *v1.ConfigMap) string {
+	// encoding/json sorts the keys of data, so the content is canonical
+	content, _ := json.Marshal(signedConfigMap{
+		Namespace: configmapObj.Namespace,
+		Name:      configmapObj.Name,
+		Data:      configmapObj.Data,
+	})
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// may the configmap carry the config template of this setup,
+// it has to be signed with the key or, if allowed, carry the key itself
+func (c *Controller) authorized(configmapObj *v1.ConfigMap) bool {
+	if c.a.Key == "" {
+		//nolint:errcheck
+		level.Warn(c.logger).Log(
+			"msg", "Ignoring config, no key is configured",
+			"namespace", configmapObj.Namespace,
+			"name", configmapObj.Name,
+		)
+		return false
+	}
+	if signature, ok := configmapObj.Annotations["alertmanager.net/signature"]; ok {
+		return hmac.Equal([]byte(signature), []byte(Signature(c.a.Key, configmapObj)))
+	}
+
+	key, ok := configmapObj.Annotations["alertmanager.net/key"]
+	if !ok {
+		return false
+	}
+	if !c.a.AllowPlainKey {
+		//nolint:errcheck
+		level.Warn(c.logger).Log(
+			"msg", "Ignoring config with the plain key, use alertmanager.net/signature instead",
+			"namespace", configmapObj.Namespace,
+			"name", configmapObj.Name,
+		)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(c.a.Key)) != 1 {
+		return false
+	}
+	//nolint:errcheck
+	level.Warn(c.logger).Log(
+		"msg", "Config carries the plain key, use alertmanager.net/signature instead",
+		"namespace", configmapObj.Namespace,
+		"name", configmapObj.Name,
+	)
+	return true
+}
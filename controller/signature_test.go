@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	v1 "k8s.io/api/core/v1"
+)
+
+// a config configmap carrying the template
+func configConfigMap(namespace string, annotations ...string) *v1.ConfigMap {
+	return configMap(namespace, "config", map[string]string{"alertmanager.tmpl": testTemplate},
+		append([]string{"alertmanager.net/config", "true"}, annotations...)...)
+}
+
+func TestAuthorized(t *testing.T) {
+	cases := []struct {
+		name          string
+		key           string
+		allowPlainKey bool
+		configMap     *v1.ConfigMap
+		want          bool
+	}{
+		{"signed", "key", false, signed(configConfigMap("monitoring")), true},
+		{"signed with another key", "other", false, signed(configConfigMap("monitoring")), false},
+		{"no key configured", "", false, signed(configConfigMap("monitoring")), false},
+		{
+			"signature replayed in another namespace",
+			"key",
+			false,
+			configConfigMap("team-a", "alertmanager.net/signature", Signature("key", configConfigMap("monitoring"))),
+			false,
+		},
+		{
+			"data changed after signing",
+			"key",
+			false,
+			func() *v1.ConfigMap {
+				configmapObj := signed(configConfigMap("monitoring"))
+				configmapObj.Data["alertmanager.tmpl"] = "route: {}\n"
+				return configmapObj
+			}(),
+			false,
+		},
+		{"unsigned", "key", false, configConfigMap("monitoring"), false},
+		{"unsigned without a key", "", true, configConfigMap("monitoring"), false},
+		{"plain key", "key", false, configConfigMap("monitoring", "alertmanager.net/key", "key"), false},
+		{"allowed plain key", "key", true, configConfigMap("monitoring", "alertmanager.net/key", "key"), true},
+		{"allowed plain key which is wrong", "key", true, configConfigMap("monitoring", "alertmanager.net/key", "other"), false},
+		{"empty plain key without a key", "", true, configConfigMap("monitoring", "alertmanager.net/key", ""), false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+				a.Key = tc.key
+				a.AllowPlainKey = tc.allowPlainKey
+			})
+			defer s.close()
+			if got := s.c.authorized(tc.configMap); got != tc.want {
+				t.Errorf("authorized = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSignatureIsCanonical(t *testing.T) {
+	a := configMap("monitoring", "config", map[string]string{"a": "1", "b": "2"})
+	b := configMap("monitoring", "config", map[string]string{"b": "2", "a": "1"}, "alertmanager.net/config", "true")
+	if Signature("key", a) != Signature("key", b) {
+		t.Errorf("signature depends on the order of data or on annotations")
+	}
+	if Signature("key", a) == Signature("other", a) {
+		t.Errorf("signature does not depend on the key")
+	}
+}
@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/go-kit/kit/log/level"
+	v1 "k8s.io/api/core/v1"
+)
+
+// a fragment written to staging storage, which is moved to target once all fragments of its configmap are written
+type stagedFile struct {
+	staging string
+	target  string
+}
+
+// content of files before they were replaced, nil for files which did not exist
+type fileSnapshot map[string][]byte
+
+// remember the content of the file, unless it is already remembered
+func (snapshot fileSnapshot) add(file string) error {
+	if _, ok := snapshot[file]; ok {
+		return nil
+	}
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		snapshot[file] = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	snapshot[file] = content
+	return nil
+}
+
+// move staged files to their targets, if one of them fails the targets are restored to their previous state,
+// the returned snapshot allows to restore them after a successful commit
+func (c *Controller) commitStaged(staged []stagedFile) (fileSnapshot, error) {
+	previous := make(fileSnapshot)
+	for _, s := range staged {
+		if err := previous.add(s.target); err != nil {
+			c.discardStaged(staged)
+			return nil, err
+		}
+	}
+
+	for i, s := range staged {
+		err := os.Rename(s.staging, s.target)
+		if err != nil {
+			c.restoreTargets(staged[:i], previous)
+			c.discardStaged(staged[i:])
+			return nil, err
+		}
+	}
+	return previous, nil
+}
+
+// put back the content targets had before they were committed
+func (c *Controller) restoreTargets(committed []stagedFile, previous fileSnapshot) {
+	for _, s := range committed {
+		c.restoreFile(s.target, previous[s.target])
+	}
+}
+
+// put back the content of all files of the snapshot
+func (c *Controller) restoreSnapshot(snapshot fileSnapshot) {
+	for file, content := range snapshot {
+		c.restoreFile(file, content)
+	}
+}
+
+// write the previous content of the file, or remove it if it did not exist
+func (c *Controller) restoreFile(file string, content []byte) {
+	var err error
+	if content != nil {
+		err = ioutil.WriteFile(file, content, 0644)
+	} else {
+		err = os.Remove(file)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		//nolint:errcheck
+		level.Error(c.logger).Log("msg", "Failed to restore file", "file", file, "err", err.Error())
+	}
+}
+
+// remove staged files which were not committed
+func (c *Controller) discardStaged(staged []stagedFile) {
+	for _, s := range staged {
+		err := os.Remove(s.staging)
+		if err != nil && !os.IsNotExist(err) {
+			//nolint:errcheck
+			level.Error(c.logger).Log("msg", "Failed to remove staged file", "file", s.staging, "err", err.Error())
+		}
+	}
+}
+
+// remove the active fragments of the old version of a configmap, which are not replaced by the committed new version,
+// their content is added to the snapshot of the commit
+func (c *Controller) deleteReplaced(oldConfigmapObj *v1.ConfigMap, oldConfigType string, newConfigmapObj *v1.ConfigMap, newConfigType string, snapshot fileSnapshot) {
+	replaced := make(map[string]bool)
+	for _, p := range parts(newConfigmapObj, newConfigType) {
+		replaced[configDir(p.configType)+"/"+p.filename] = true
+	}
+	for _, p := range parts(oldConfigmapObj, oldConfigType) {
+		if replaced[configDir(p.configType)+"/"+p.filename] {
+			continue
+		}
+		//nolint:errcheck
+		level.Info(c.logger).Log(
+			"msg", "Deleting "+p.configType+": "+p.key,
+			"namespace", oldConfigmapObj.Namespace,
+			"name", oldConfigmapObj.Name,
+		)
+		file := c.a.ConfigPath + "/" + configDir(p.configType) + "/" + p.filename
+		err := snapshot.add(file)
+		if err == nil {
+			err = os.Remove(file)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			//nolint:errcheck
+			level.Error(c.logger).Log(
+				"msg", "Failed to delete "+p.configType+": "+p.key,
+				"namespace", oldConfigmapObj.Namespace,
+				"name", oldConfigmapObj.Name,
+				"err", err.Error(),
+			)
+		}
+	}
+}
+
+// put back the files replaced by a new version of a configmap which failed to build, the old version stays active
+func (c *Controller) restoreReplaced(oldConfigmapObj *v1.ConfigMap, oldConfigType string, isOldTarget bool, snapshot fileSnapshot) {
+	c.restoreSnapshot(snapshot)
+	if !isOldTarget || oldConfigType == configConst {
+		return
+	}
+	c.trackFragments(oldConfigmapObj, oldConfigType, "")
+	if oldConfigType != overlayConst {
+		c.trackQuota(oldConfigmapObj, oldConfigmapObj, oldConfigType)
+	}
+}
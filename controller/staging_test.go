@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommitStaged(t *testing.T) {
+	s := newTestSetup(t)
+	defer s.close()
+	s.writeFile("config/receivers/a.yaml", "old a")
+	s.writeFile("config/staging-receivers/a.yaml", "new a")
+	s.writeFile("config/staging-receivers/b.yaml", "new b")
+	dir := filepath.Join(s.c.a.ConfigPath, "staging-receivers")
+	staged := []stagedFile{
+		{staging: filepath.Join(dir, "a.yaml"), target: filepath.Join(s.c.a.ConfigPath, "receivers/a.yaml")},
+		{staging: filepath.Join(dir, "b.yaml"), target: filepath.Join(s.c.a.ConfigPath, "receivers/b.yaml")},
+		// the third file was never staged, so it can not be moved
+		{staging: filepath.Join(dir, "c.yaml"), target: filepath.Join(s.c.a.ConfigPath, "receivers/c.yaml")},
+	}
+
+	if _, err := s.c.commitStaged(staged); err == nil {
+		t.Fatal("commit of a missing staged file succeeded")
+	}
+	if got := s.readFile("receivers/a.yaml"); got != "old a" {
+		t.Errorf("receivers/a.yaml = %q, want the previous content", got)
+	}
+	if got := s.files("receivers"); !equalStrings(got, []string{"a.yaml"}) {
+		t.Errorf("receivers = %v, want the previous files", got)
+	}
+	if got := s.files("staging-receivers"); len(got) != 0 {
+		t.Errorf("staged files %v were not discarded", got)
+	}
+}
+
+func TestUpdateIsAtomic(t *testing.T) {
+	twoKeys := configMap("team-a", "r", map[string]string{
+		"a.yaml": "- name: a\n  webhook_configs:\n  - url: http://a\n",
+		"b.yaml": "- name: b\n  webhook_configs:\n  - url: http://b\n",
+	}, "alertmanager.net/receiver", "true")
+	cases := []struct {
+		name string
+		// the new version of the configmap
+		update func(s *testSetup) interface{}
+		// receiver files and their urls after the update
+		receivers []string
+		urls      []string
+	}{
+		{
+			name: "changed key is replaced and removed key is deleted",
+			update: func(s *testSetup) interface{} {
+				return configMap("team-a", "r", map[string]string{
+					"a.yaml": "- name: a\n  webhook_configs:\n  - url: http://changed\n",
+				}, "alertmanager.net/receiver", "true")
+			},
+			receivers: []string{"team-a-r-a.yaml"},
+			urls:      []string{"http://changed"},
+		},
+		{
+			name: "failed write keeps the old version",
+			update: func(s *testSetup) interface{} {
+				// a file in place of the staging directory makes writing the new version fail
+				os.RemoveAll(filepath.Join(s.c.a.ConfigPath, "staging-receivers"))
+				s.writeFile("config/staging-receivers", "")
+				return configMap("team-a", "r", map[string]string{
+					"a.yaml": "- name: a\n  webhook_configs:\n  - url: http://changed\n",
+					"c.yaml": "- name: c\n  webhook_configs:\n  - url: http://c\n",
+				}, "alertmanager.net/receiver", "true")
+			},
+			receivers: []string{"team-a-r-a.yaml", "team-a-r-b.yaml"},
+			urls:      []string{"http://a", "http://b"},
+		},
+		{
+			name: "rejected version removes the old one",
+			update: func(s *testSetup) interface{} {
+				return configMap("team-a", "r", map[string]string{
+					"a.yaml": "- name: a\n  webhook_configs:\n  - url: http://${UNDEFINED_VARIABLE}\n",
+				}, "alertmanager.net/receiver", "true")
+			},
+			receivers: []string{},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetup(t)
+			defer s.close()
+			s.c.a.Substitute = true
+			s.c.Create(twoKeys)
+			s.c.Update(twoKeys, tc.update(s))
+
+			if got := s.files("receivers"); !equalStrings(got, tc.receivers) {
+				t.Errorf("receivers = %v, want %v", got, tc.receivers)
+			}
+			var content string
+			for _, f := range tc.receivers {
+				content += s.readFile("receivers/" + f)
+			}
+			for _, url := range tc.urls {
+				if !strings.Contains(content, url) || !strings.Contains(s.config(), url) {
+					t.Errorf("receivers or config do not contain %s:\n%s", url, s.config())
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateFailingToBuildKeepsOldVersion(t *testing.T) {
+	cases := []struct {
+		name string
+		// data of the new version of the route configmap
+		data map[string]string
+	}{
+		{
+			name: "changed key",
+			data: map[string]string{"route.yaml": "- receiver: missing\n"},
+		},
+		{
+			name: "renamed key",
+			data: map[string]string{"other.yaml": "- receiver: missing\n"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetup(t)
+			defer s.close()
+			s.c.Create(receiverConfigMap("team-a", "receiver", "a"))
+			route := routeConfigMap("team-a", "route", "a")
+			s.c.Create(route)
+			active := s.readFile("routes/team-a-route-route.yaml")
+
+			s.c.Update(route, configMap("team-a", "route", tc.data, "alertmanager.net/route", "true"))
+
+			if got := s.files("routes"); !equalStrings(got, []string{"team-a-route-route.yaml"}) {
+				t.Errorf("routes = %v, want the old route", got)
+			}
+			if got := s.readFile("routes/team-a-route-route.yaml"); got != active {
+				t.Errorf("route = %q, want the old route %q", got, active)
+			}
+			if !strings.Contains(s.config(), "receiver: a\n") || strings.Contains(s.config(), "missing") {
+				t.Errorf("config does not route to the old receiver only:\n%s", s.config())
+			}
+			if len(s.files("backup-routes")) == 0 {
+				t.Errorf("new version is not backed up")
+			}
+
+			// once the new version builds it replaces the old one
+			s.c.Create(receiverConfigMap("team-a", "missing", "missing"))
+			if !strings.Contains(s.config(), "receiver: missing\n") {
+				t.Errorf("config does not route to the new receiver:\n%s", s.config())
+			}
+		})
+	}
+}
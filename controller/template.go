@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	"gopkg.in/yaml.v2"
+)
+
+// line of the template in errors of text/template, e.g. "template: alertmanager.yml:12:3: ..."
+var templateLine = regexp.MustCompile(`^template: [^:]+:(\d+)`)
+
+// functions available in the template of alertmanager.yml, env is added for every setup
+var templateFuncs = template.FuncMap{
+	"indent":    indent,
+	"nindent":   nindent,
+	"toYaml":    toYaml,
+	"default":   defaultValue,
+	"required":  required,
+	"sortAlpha": sortAlpha,
+}
+
+// TemplateError of parsing or executing the template of alertmanager.yml
+type TemplateError struct {
+	Line   int
+	Source string
+	Err    error
+}
+
+func (e *TemplateError) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	if e.Source == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err.Error())
+	}
+	return fmt.Sprintf("line %d: %s, near: %s", e.Line, e.Err.Error(), e.Source)
+}
+
+// prefix every line of s with n spaces
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// like indent, but starts with a newline
+func nindent(n int, s string) string {
+	return "\n" + indent(n, s)
+}
+
+// encode v as YAML without the trailing newline
+func toYaml(v interface{}) (string, error) {
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// reads the environment variables in allowed, others fail rendering
+func env(allowed []string) func(string) (string, error) {
+	return func(name string) (string, error) {
+		for _, a := range allowed {
+			if a == name {
+				return os.Getenv(name), nil
+			}
+		}
+		return "", fmt.Errorf("environment variable %s is not listed in substitute_env", name)
+	}
+}
+
+// v or def if v is empty
+func defaultValue(def interface{}, v interface{}) interface{} {
+	if isEmpty(v) {
+		return def
+	}
+	return v
+}
+
+// v or an error with msg if v is empty
+func required(msg string, v interface{}) (interface{}, error) {
+	if isEmpty(v) {
+		return nil, fmt.Errorf("%s", msg)
+	}
+	return v, nil
+}
+
+// sorted copy of a list of strings
+func sortAlpha(list []string) []string {
+	sorted := append([]string(nil), list...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return value.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	}
+	return reflect.DeepEqual(v, reflect.Zero(value.Type()).Interface())
+}
+
+// read and parse the template of alertmanager.yml
+func (c *Controller) parseTemplate() (*template.Template, []byte, error) {
+	configTemplate, err := ioutil.ReadFile(c.a.ConfigTemplate)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := template.New("alertmanager.yml").Funcs(templateFuncs).Funcs(template.FuncMap{"env": env(c.a.Env)}).Parse(string(configTemplate))
+	if err != nil {
+		return nil, configTemplate, templateError(err, configTemplate)
+	}
+	return t, configTemplate, nil
+}
+
+// render the template with the fragments in alertmanagerConfig and the data derived from them
+func (c *Controller) executeTemplate(t *template.Template, configTemplate []byte, alertmanagerConfig alertmanager.Config) ([]byte, error) {
+	alertmanagerConfig.ID = c.a.ID
+	alertmanagerConfig.Namespaces = c.activeNamespaces()
+	alertmanagerConfig.ReceiverNames = receiverNames(alertmanagerConfig.Receivers)
+
+	var tpl bytes.Buffer
+	if c.a.NamespaceRoutes {
+		// the default routes may also use the receivers of the template itself
+		if err := t.Execute(&tpl, alertmanagerConfig); err == nil {
+			alertmanagerConfig.Routes += c.namespaceRoutes(definedReceivers(tpl.Bytes()))
+		}
+		tpl.Reset()
+	}
+	err := t.Execute(&tpl, alertmanagerConfig)
+	if err != nil {
+		return nil, templateError(err, configTemplate)
+	}
+	return tpl.Bytes(), nil
+}
+
+// add the line number and the line of the template to an error of text/template
+func templateError(err error, configTemplate []byte) error {
+	match := templateLine.FindStringSubmatch(err.Error())
+	if match == nil {
+		return &TemplateError{Err: err}
+	}
+	line, _ := strconv.Atoi(match[1])
+	lines := strings.Split(string(configTemplate), "\n")
+	source := ""
+	if line > 0 && line <= len(lines) {
+		source = strings.TrimSpace(lines[line-1])
+	}
+	return &TemplateError{Line: line, Source: source, Err: err}
+}
+
+// namespaces of all configmaps with active routes, receivers or inhibit rules, sorted
+func (c *Controller) activeNamespaces() []string {
+	return c.namespacesOf([]string{"routes", "receivers", "inhibit-rules"})
+}
+
+// namespaces of all configmaps with active fragments in dirs, sorted
+func (c *Controller) namespacesOf(dirs []string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	seen := make(map[string]bool)
+	namespaces := []string{}
+	for _, dir := range dirs {
+		files, _ := filepath.Glob(c.a.ConfigPath + "/" + dir + "/*")
+		for _, file := range files {
+			s, ok := c.sources[dir+"/"+filepath.Base(file)]
+			if !ok || seen[s.namespace] {
+				continue
+			}
+			seen[s.namespace] = true
+			namespaces = append(namespaces, s.namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// names of the receivers in the receivers fragments, sorted
+func receiverNames(receivers string) []string {
+	var list []struct {
+		Name string `yaml:"name"`
+	}
+	names := []string{}
+	// broken fragments are reported by the validation of the rendered config
+	_ = yaml.Unmarshal([]byte(receivers), &list)
+	for _, r := range list {
+		if r.Name != "" {
+			names = append(names, r.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		data     interface{}
+		want     string
+		// error contains, empty if the template is rendered
+		err string
+	}{
+		{"indent", `{{ indent 2 "a\nb" }}`, nil, "  a\n  b", ""},
+		{"nindent", `x:{{ nindent 2 "a: 1" }}`, nil, "x:\n  a: 1", ""},
+		{"toYaml", `{{ toYaml . }}`, map[string][]string{"group_by": {"alertname"}}, "group_by:\n- alertname", ""},
+		{"default of an empty string", `{{ default "5m" "" }}`, nil, "5m", ""},
+		{"default of a value", `{{ default "5m" "1m" }}`, nil, "1m", ""},
+		{"default of an empty list", `{{ default "none" . }}`, []string{}, "none", ""},
+		{"default of zero", `{{ default 3 0 }}`, nil, "3", ""},
+		{"required value", `{{ required "id is required" "platform" }}`, nil, "platform", ""},
+		{"required empty value", `{{ required "id is required" "" }}`, nil, "", "id is required"},
+		{"sortAlpha", `{{ sortAlpha . }}`, []string{"b", "c", "a"}, "[a b c]", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tpl, err := template.New("t").Funcs(templateFuncs).Parse(tc.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out strings.Builder
+			err = tpl.Execute(&out, tc.data)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error = %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tc.want {
+				t.Errorf("rendered %q, want %q", out.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestSortAlphaCopies(t *testing.T) {
+	list := []string{"b", "a"}
+	sortAlpha(list)
+	if !equalStrings(list, []string{"b", "a"}) {
+		t.Errorf("sortAlpha changed its argument to %v", list)
+	}
+}
+
+func TestTemplateData(t *testing.T) {
+	s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+		a.ID = "platform"
+	})
+	defer s.close()
+	for _, configmapObj := range []*v1.ConfigMap{receiverConfigMap("team-b", "r", "b"), receiverConfigMap("team-a", "r", "a")} {
+		configmapObj.Annotations["alertmanager.net/id"] = "platform"
+		s.c.Create(configmapObj)
+	}
+
+	tpl, configTemplate, err := s.c.parseTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl, err = tpl.Parse(`{{ .ID }} {{ .Namespaces }} {{ .ReceiverNames }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := s.c.executeTemplate(tpl, configTemplate, alertmanager.Config{Receivers: s.c.readConfigs("receivers")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "platform [team-a team-b] [a b]" {
+		t.Errorf("rendered %q, want the id, namespaces and receiver names", out)
+	}
+}
+
+func TestTemplateEnv(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		want     string
+		// error contains, empty if the template is rendered
+		err string
+	}{
+		{"listed variable", `{{ env "TEST_TEMPLATE_ENV" }}`, "env", ""},
+		{"listed variable which is not set", `{{ env "TEST_TEMPLATE_UNSET" | default "none" }}`, "none", ""},
+		{"variable which is not listed", `{{ env "TEST_TEMPLATE_SECRET" }}`, "", "TEST_TEMPLATE_SECRET is not listed in substitute_env"},
+	}
+	os.Setenv("TEST_TEMPLATE_ENV", "env")
+	os.Setenv("TEST_TEMPLATE_SECRET", "secret")
+	defer os.Unsetenv("TEST_TEMPLATE_ENV")
+	defer os.Unsetenv("TEST_TEMPLATE_SECRET")
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+				a.Env = []string{"TEST_TEMPLATE_ENV", "TEST_TEMPLATE_UNSET"}
+			})
+			defer s.close()
+			s.writeFile("template/alertmanager.tmpl", tc.template)
+
+			tpl, configTemplate, err := s.c.parseTemplate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := s.c.executeTemplate(tpl, configTemplate, alertmanager.Config{})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error = %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tc.want {
+				t.Errorf("rendered %q, want %q", out, tc.want)
+			}
+		})
+	}
+}
+
+func TestTemplateError(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		// line and source of the template in the error
+		line   string
+		source string
+	}{
+		{"parse error", "global:\n  resolve_timeout: 5m\n  smtp_from: {{ end }}\n", "line 3: ", ", near: smtp_from: {{ end }}"},
+		{"execution error", "global:\n  id: {{ required \"id is required\" \"\" }}\n", "line 2: ", `, near: id: {{ required "id is required" "" }}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetup(t)
+			defer s.close()
+			s.writeFile("template/alertmanager.tmpl", tc.template)
+
+			err := s.c.buildConfig()
+			if err == nil || !strings.HasPrefix(err.Error(), tc.line) || !strings.HasSuffix(err.Error(), tc.source) {
+				t.Errorf("error = %v, want it to start with %q and end with %q", err, tc.line, tc.source)
+			}
+		})
+	}
+}
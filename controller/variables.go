@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ${NAME} placeholders in fragments, $${NAME} is kept as ${NAME}
+var variablePattern = regexp.MustCompile(`\$(\$?)\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// replace the placeholders in content, names of variables without a value are returned sorted
+func (c *Controller) substitute(content string) (string, []string) {
+	missing := make(map[string]bool)
+	result := variablePattern.ReplaceAllStringFunc(content, func(placeholder string) string {
+		match := variablePattern.FindStringSubmatch(placeholder)
+		if match[1] != "" {
+			return placeholder[1:]
+		}
+		v, ok := c.a.Vars[match[2]]
+		if !ok {
+			missing[match[2]] = true
+			return placeholder
+		}
+		return v
+	})
+
+	var unresolved []string
+	for name := range missing {
+		unresolved = append(unresolved, name)
+	}
+	sort.Strings(unresolved)
+	return result, unresolved
+}
+
+// replace the placeholders in the fragments of a configmap before it is admitted,
+// the error lists the variables without a value by key
+func (c *Controller) substituteVariables(configmapObj *v1.ConfigMap, configType string) (*v1.ConfigMap, error) {
+	if !c.a.Substitute || configType == configConst {
+		return configmapObj, nil
+	}
+	substituted := configmapObj.DeepCopy()
+	var violations []string
+	for _, p := range parts(configmapObj, configType) {
+		v, unresolved := c.substitute(p.content)
+		if len(unresolved) > 0 {
+			violations = append(violations, p.key+": "+strings.Join(unresolved, ", "))
+		}
+		substituted.Data[p.key] = v
+	}
+	if len(violations) > 0 {
+		return substituted, fmt.Errorf("%s", strings.Join(violations, "; "))
+	}
+	return substituted, nil
+}
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dbsystel/alertmanager-config-controller/alertmanager"
+	"github.com/dbsystel/alertmanager-config-controller/policy"
+	v1 "k8s.io/api/core/v1"
+)
+
+// a receiver configmap with the fragment content
+func variableConfigMap(content string) *v1.ConfigMap {
+	return configMap("team-a", "a", map[string]string{"receiver.yaml": content}, "alertmanager.net/receiver", "true")
+}
+
+func TestSubstituteVariables(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		policy  *policy.Policy
+		// content of the active receiver, empty if it is not active
+		active      string
+		quarantined bool
+		rejected    string
+	}{
+		{
+			name:    "placeholders are replaced",
+			content: "- name: a\n  webhook_configs:\n  - url: ${URL}\n",
+			active:  "- name: a\n  webhook_configs:\n  - url: http://a\n",
+		},
+		{
+			name:    "escaped placeholders are kept",
+			content: "- name: a\n  webhook_configs:\n  - url: http://a/$${URL}\n",
+			active:  "- name: a\n  webhook_configs:\n  - url: http://a/${URL}\n",
+		},
+		{
+			name:        "unresolved variables are quarantined",
+			content:     "- name: a\n  webhook_configs:\n  - url: ${MISSING}\n",
+			quarantined: true,
+		},
+		{
+			name:     "policy applies to the expanded content",
+			content:  "- name: a\n  ${TYPE}:\n  - to: a@example.com\n",
+			policy:   &policy.Policy{Receivers: &policy.ReceiverRule{AllowedTypes: []string{"webhook_configs"}}},
+			rejected: "email_configs",
+		},
+		{
+			name:        "quota applies to the expanded content",
+			content:     "- name: a\n  webhook_configs:\n  - url: ${LONG_URL}\n",
+			policy:      &policy.Policy{Quotas: &policy.Quotas{Default: &policy.Quota{MaxBytes: 100}}},
+			quarantined: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+				a.Substitute = true
+				a.Vars = map[string]string{
+					"URL":      "http://a",
+					"TYPE":     "email_configs",
+					"LONG_URL": "http://a/" + strings.Repeat("x", 100),
+				}
+				a.Policy = tc.policy
+			})
+			defer s.close()
+			s.c.Create(variableConfigMap(tc.content))
+
+			if got := s.readFile("receivers/team-a-a-receiver.yaml"); got != tc.active {
+				t.Errorf("active receiver = %q, want %q", got, tc.active)
+			}
+			if got := s.exists("quarantine-receivers/team-a-a-receiver.yaml"); got != tc.quarantined {
+				t.Errorf("quarantined = %v, want %v", got, tc.quarantined)
+			}
+			rejections := s.c.Rejections()
+			if tc.rejected == "" && len(rejections) != 0 {
+				t.Errorf("rejections = %v, want none", rejections)
+			}
+			if tc.rejected != "" && (len(rejections) != 1 || !strings.Contains(rejections[0].Reason, tc.rejected)) {
+				t.Errorf("rejections = %v, want one naming %s", rejections, tc.rejected)
+			}
+		})
+	}
+}
+
+func TestUnresolvedVariablesReason(t *testing.T) {
+	s := newTestSetupWith(t, func(a *alertmanager.APIClient) {
+		a.Substitute = true
+		a.Vars = map[string]string{"A": "a"}
+	})
+	defer s.close()
+	configmapObj := configMap("team-a", "a", map[string]string{
+		"one.yaml": "${B}/${A}/${C}",
+		"two.yaml": "${D}",
+	}, "alertmanager.net/receiver", "true")
+
+	substituted, err := s.c.substituteVariables(configmapObj, receiverConst)
+	want := "one.yaml: B, C; two.yaml: D"
+	if err == nil || err.Error() != want {
+		t.Errorf("err = %v, want %s", err, want)
+	}
+	if got := substituted.Data["one.yaml"]; got != "${B}/a/${C}" {
+		t.Errorf("substituted = %q, want %q", got, "${B}/a/${C}")
+	}
+	if got := configmapObj.Data["one.yaml"]; got != "${B}/${A}/${C}" {
+		t.Errorf("received configmap changed to %q", got)
+	}
+}
@@ -0,0 +1,55 @@
+package event
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var component = "alertmanager-config-controller"
+
+// Recorder reports what happened to a configmap as a Kubernetes event
+type Recorder interface {
+	// Warning records a warning event on the configmap
+	Warning(configmapObj *v1.ConfigMap, reason string, message string) error
+}
+
+// Client creates the events with the Kubernetes API
+type Client struct {
+	Client kubernetes.Interface
+}
+
+// New returns a Recorder creating events with client
+func New(client kubernetes.Interface) *Client {
+	return &Client{Client: client}
+}
+
+// Warning creates a warning event in the namespace of the configmap
+func (c *Client) Warning(configmapObj *v1.ConfigMap, reason string, message string) error {
+	now := metav1.NewTime(time.Now())
+	_, err := c.Client.CoreV1().Events(configmapObj.Namespace).Create(&v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", configmapObj.Name, now.UnixNano()),
+			Namespace: configmapObj.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:            "ConfigMap",
+			APIVersion:      "v1",
+			Namespace:       configmapObj.Namespace,
+			Name:            configmapObj.Name,
+			UID:             configmapObj.UID,
+			ResourceVersion: configmapObj.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           v1.EventTypeWarning,
+		Source:         v1.EventSource{Component: component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	})
+	return err
+}
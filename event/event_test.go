@@ -0,0 +1,36 @@
+package event
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWarning(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	configmapObj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "config", UID: "uid"}}
+	err := New(client).Warning(configmapObj, "Rejected", "Rejected config: not allowed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := client.CoreV1().Events("team-a").List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Items) != 1 {
+		t.Fatalf("%d events, want one", len(events.Items))
+	}
+	e := events.Items[0]
+	if e.Type != v1.EventTypeWarning || e.Reason != "Rejected" || e.Message != "Rejected config: not allowed" {
+		t.Errorf("event is %s %s %q, want a Rejected warning with the message", e.Type, e.Reason, e.Message)
+	}
+	if e.InvolvedObject.Kind != "ConfigMap" || e.InvolvedObject.Name != "config" || e.InvolvedObject.UID != "uid" {
+		t.Errorf("event involves %+v, want the configmap", e.InvolvedObject)
+	}
+	if e.Source.Component != component {
+		t.Errorf("event source is %q, want %q", e.Source.Component, component)
+	}
+}
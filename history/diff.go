@@ -0,0 +1,151 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+)
+
+// number of unchanged lines shown around a change
+var diffContext = 3
+
+// single line of an edit script: ' ' keeps, '-' deletes and '+' inserts a line
+type edit struct {
+	kind byte
+	a    int
+	b    int
+	line string
+}
+
+// unifiedDiff formats the difference of two texts like diff -u
+func unifiedDiff(fromLabel, toLabel, from, to string) string {
+	edits := diffLines(splitLines(from), splitLines(to))
+
+	var out strings.Builder
+	i := 0
+	for i < len(edits) {
+		for i < len(edits) && edits[i].kind == ' ' {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+		if out.Len() == 0 {
+			out.WriteString("--- " + fromLabel + "\n")
+			out.WriteString("+++ " + toLabel + "\n")
+		}
+
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		lastChange := i
+		for j := i; j < len(edits) && j-lastChange <= 2*diffContext; j++ {
+			if edits[j].kind != ' ' {
+				lastChange = j
+			}
+		}
+		end := lastChange + diffContext + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		fromCount, toCount := 0, 0
+		for _, e := range edits[start:end] {
+			if e.kind != '+' {
+				fromCount++
+			}
+			if e.kind != '-' {
+				toCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n",
+			hunkStart(edits[start].a, fromCount), fromCount,
+			hunkStart(edits[start].b, toCount), toCount)
+		for _, e := range edits[start:end] {
+			out.WriteString(string(e.kind) + e.line + "\n")
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// line numbers of hunks are 1-based, empty hunks point to the line before
+func hunkStart(index, count int) int {
+	if count == 0 {
+		return index
+	}
+	return index + 1
+}
+
+// diffLines computes the shortest edit script from a to b (Myers' algorithm)
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		snapshot := make([]int, len(v))
+		copy(snapshot, v)
+		trace = append(trace, snapshot)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			edits = append(edits, edit{kind: ' ', a: x - 1, b: y - 1, line: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, edit{kind: '+', a: x, b: y - 1, line: b[y-1]})
+			} else {
+				edits = append(edits, edit{kind: '-', a: x - 1, b: y, line: a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,37 @@
+package history
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	cases := []struct {
+		name string
+		from string
+		to   string
+		want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"both empty", "", "", ""},
+		{"added to empty", "", "a\n", "--- from\n+++ to\n@@ -0,0 +1,1 @@\n+a\n"},
+		{"removed all", "a\n", "", "--- from\n+++ to\n@@ -1,1 +0,0 @@\n-a\n"},
+		{"changed line", "a\nb\nc\n", "a\nx\nc\n", "--- from\n+++ to\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{
+			"context is limited",
+			"1\n2\n3\n4\n5\n6\n7\n8\n",
+			"1\n2\n3\n4\n5\n6\n7\nx\n",
+			"--- from\n+++ to\n@@ -5,4 +5,4 @@\n 5\n 6\n 7\n-8\n+x\n",
+		},
+		{
+			"distant changes are separate hunks",
+			"a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+			"A\n1\n2\n3\n4\n5\n6\n7\n8\nB\n",
+			"--- from\n+++ to\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b\n+B\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := unifiedDiff("from", "to", tc.from, tc.to); got != tc.want {
+				t.Errorf("unifiedDiff =\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
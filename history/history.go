@@ -0,0 +1,180 @@
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	configFile     = "alertmanager.yml"
+	generationFile = "generation.yml"
+)
+
+// Generation of a rendered alertmanager.yml
+type Generation struct {
+	ID        int       `yaml:"id"`
+	Timestamp time.Time `yaml:"timestamp"`
+	Trigger   string    `yaml:"trigger"`
+	Hash      string    `yaml:"hash"`
+}
+
+// Store keeps the last generations of alertmanager.yml in a directory
+type Store struct {
+	Path  string
+	Limit int
+}
+
+// New returns a Store which keeps limit generations in path
+func New(path string, limit int) *Store {
+	return &Store{
+		Path:  path,
+		Limit: limit,
+	}
+}
+
+// Save stores config as new generation, unless it equals the newest one
+func (s *Store) Save(config []byte, trigger string) (*Generation, error) {
+	if s.Limit <= 0 {
+		return nil, nil
+	}
+	generations, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	generation := &Generation{
+		ID:        1,
+		Timestamp: time.Now().UTC(),
+		Trigger:   trigger,
+		Hash:      hash(config),
+	}
+	if len(generations) > 0 {
+		newest := generations[len(generations)-1]
+		if newest.Hash == generation.Hash {
+			return nil, nil
+		}
+		generation.ID = newest.ID + 1
+	}
+
+	path := s.dir(generation.ID)
+	err = os.MkdirAll(path, 0766)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(filepath.Join(path, configFile), config, 0644)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := yaml.Marshal(generation)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(filepath.Join(path, generationFile), meta, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	generations = append(generations, *generation)
+	for len(generations) > s.Limit {
+		err = os.RemoveAll(s.dir(generations[0].ID))
+		if err != nil {
+			return generation, err
+		}
+		generations = generations[1:]
+	}
+	return generation, nil
+}
+
+// List returns all kept generations, oldest first
+func (s *Store) List() ([]Generation, error) {
+	dirs, err := ioutil.ReadDir(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var generations []Generation
+	for _, dir := range dirs {
+		id, err := strconv.Atoi(dir.Name())
+		if !dir.IsDir() || err != nil {
+			continue
+		}
+		generation, err := s.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		generations = append(generations, *generation)
+	}
+	sort.Slice(generations, func(i, j int) bool {
+		return generations[i].ID < generations[j].ID
+	})
+	return generations, nil
+}
+
+// Get returns the metadata of a generation
+func (s *Store) Get(id int) (*Generation, error) {
+	meta, err := ioutil.ReadFile(filepath.Join(s.dir(id), generationFile))
+	if err != nil {
+		return nil, fmt.Errorf("generation %d not found: %s", id, err)
+	}
+	generation := &Generation{}
+	err = yaml.Unmarshal(meta, generation)
+	if err != nil {
+		return nil, fmt.Errorf("generation %d is corrupt: %s", id, err)
+	}
+	return generation, nil
+}
+
+// Read returns the alertmanager.yml of a generation
+func (s *Store) Read(id int) ([]byte, error) {
+	config, err := ioutil.ReadFile(filepath.Join(s.dir(id), configFile))
+	if err != nil {
+		return nil, fmt.Errorf("generation %d not found: %s", id, err)
+	}
+	return config, nil
+}
+
+// Diff returns a unified diff between the alertmanager.yml of two generations
+func (s *Store) Diff(from, to int) (string, error) {
+	fromGeneration, err := s.Get(from)
+	if err != nil {
+		return "", err
+	}
+	toGeneration, err := s.Get(to)
+	if err != nil {
+		return "", err
+	}
+	fromConfig, err := s.Read(from)
+	if err != nil {
+		return "", err
+	}
+	toConfig, err := s.Read(to)
+	if err != nil {
+		return "", err
+	}
+	return unifiedDiff(label(fromGeneration), label(toGeneration), string(fromConfig), string(toConfig)), nil
+}
+
+func (s *Store) dir(id int) string {
+	return filepath.Join(s.Path, strconv.Itoa(id))
+}
+
+func label(g *Generation) string {
+	return "generation " + strconv.Itoa(g.ID) + "\t" + g.Timestamp.Format(time.RFC3339)
+}
+
+func hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,141 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func tempStore(t *testing.T, limit int) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(filepath.Join(dir, "history"), limit), func() { os.RemoveAll(dir) }
+}
+
+func TestSave(t *testing.T) {
+	cases := []struct {
+		name    string
+		limit   int
+		configs []string
+		// ids of the kept generations
+		want []int
+	}{
+		{"first generation", 3, []string{"a"}, []int{1}},
+		{"unchanged config is not saved again", 3, []string{"a", "a", "b", "b"}, []int{1, 2}},
+		{"same config is saved again after a change", 3, []string{"a", "b", "a"}, []int{1, 2, 3}},
+		{"oldest generations are dropped", 2, []string{"a", "b", "c", "d"}, []int{3, 4}},
+		{"disabled history", 0, []string{"a", "b"}, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, cleanup := tempStore(t, tc.limit)
+			defer cleanup()
+			for _, c := range tc.configs {
+				if _, err := s.Save([]byte(c), "ns/"+c); err != nil {
+					t.Fatal(err)
+				}
+			}
+			generations, err := s.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, g := range generations {
+				ids = append(ids, g.ID)
+			}
+			if len(ids) != len(tc.want) {
+				t.Fatalf("generations = %v, want %v", ids, tc.want)
+			}
+			for i := range ids {
+				if ids[i] != tc.want[i] {
+					t.Fatalf("generations = %v, want %v", ids, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	s, cleanup := tempStore(t, 5)
+	defer cleanup()
+	g, err := s.Save([]byte("route: {}\n"), "monitoring/routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Get(g.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Trigger != "monitoring/routes" || got.Hash != hash([]byte("route: {}\n")) || got.Timestamp.IsZero() {
+		t.Errorf("generation = %+v, want trigger, hash and timestamp", got)
+	}
+	config, err := s.Read(g.ID)
+	if err != nil || string(config) != "route: {}\n" {
+		t.Errorf("Read = %q, %v", config, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s, cleanup := tempStore(t, 5)
+	defer cleanup()
+	if _, err := s.Save([]byte("a"), "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.dir(1), generationFile), []byte("id: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		call func() error
+		want string
+	}{
+		{"unknown generation", func() error { _, err := s.Get(7); return err }, "generation 7 not found"},
+		{"unknown config", func() error { _, err := s.Read(7); return err }, "generation 7 not found"},
+		{"corrupt generation", func() error { _, err := s.Get(1); return err }, "generation 1 is corrupt"},
+		{"diff with unknown generation", func() error { _, err := s.Diff(7, 1); return err }, "generation 7 not found"},
+		{"list with corrupt generation", func() error { _, err := s.List(); return err }, "generation 1 is corrupt"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.call()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestListWithoutHistory(t *testing.T) {
+	s, cleanup := tempStore(t, 5)
+	defer cleanup()
+	generations, err := s.List()
+	if err != nil || len(generations) != 0 {
+		t.Errorf("List = %v, %v, want no generations", generations, err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	s, cleanup := tempStore(t, 5)
+	defer cleanup()
+	for _, c := range []string{"a\nb\nc\n", "a\nB\nc\n"} {
+		if _, err := s.Save([]byte(c), "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	diff, err := s.Diff(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(diff, "--- generation 1\t") || !strings.Contains(diff, "\n+++ generation 2\t") {
+		t.Errorf("diff has no labels of the generations:\n%s", diff)
+	}
+	if !strings.HasSuffix(diff, "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n") {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+}
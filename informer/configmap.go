@@ -0,0 +1,101 @@
+package informer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dbsystel/kube-controller-dbsystel-go-common/controller"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ConfigMapController watches only the configmaps matching LabelSelector in Namespaces
+type ConfigMapController struct {
+	Controller    controller.Controller
+	Namespaces    []string
+	LabelSelector string
+	informers     []cache.SharedIndexInformer
+	kclient       kubernetes.Interface
+}
+
+// Run all informers until stopCh is closed, the caller adds it to wg before starting it
+func (cc *ConfigMapController) Run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for _, informer := range cc.informers {
+		go informer.Run(stopCh)
+	}
+	<-stopCh
+}
+
+// HasSynced tells whether all informers listed their configmaps
+func (cc *ConfigMapController) HasSynced() bool {
+	for _, informer := range cc.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// List the configmaps in the caches of all informers
+func (cc *ConfigMapController) List() []interface{} {
+	var objs []interface{}
+	for _, informer := range cc.informers {
+		objs = append(objs, informer.GetStore().List()...)
+	}
+	return objs
+}
+
+// Initialize one informer per namespace, or a single one for all namespaces
+func (cc *ConfigMapController) Initialize(kclient kubernetes.Interface) {
+	namespaces := cc.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	cc.informers = nil
+	for _, namespace := range namespaces {
+		cc.informers = append(cc.informers, cc.newInformer(kclient, namespace))
+	}
+	cc.kclient = kclient
+}
+
+func (cc *ConfigMapController) newInformer(kclient kubernetes.Interface, namespace string) cache.SharedIndexInformer {
+	informer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = cc.LabelSelector
+				return kclient.CoreV1().ConfigMaps(namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = cc.LabelSelector
+				return kclient.CoreV1().ConfigMaps(namespace).Watch(options)
+			},
+		},
+		&v1.ConfigMap{},
+		3*time.Minute,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+	)
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    cc.Controller.Create,
+		UpdateFunc: cc.Controller.Update,
+		DeleteFunc: cc.delete,
+	})
+	return informer
+}
+
+// configmaps leaving the label selector may only be known by their last state
+func (cc *ConfigMapController) delete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if _, ok := obj.(*v1.ConfigMap); ok {
+		cc.Controller.Delete(obj)
+	}
+}
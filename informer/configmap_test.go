@@ -0,0 +1,141 @@
+package informer
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// recorder remembers the events it gets as "<event> <namespace>/<name>"
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(event string, obj interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch o := obj.(type) {
+	case *v1.ConfigMap:
+		r.events = append(r.events, event+" "+o.Namespace+"/"+o.Name)
+	case *v1.Namespace:
+		r.events = append(r.events, event+" "+o.Name)
+	default:
+		r.events = append(r.events, event+" unknown")
+	}
+}
+
+func (r *recorder) Create(obj interface{})            { r.record("create", obj) }
+func (r *recorder) Update(oldobj, newobj interface{}) { r.record("update", newobj) }
+func (r *recorder) Delete(obj interface{})            { r.record("delete", obj) }
+
+// wait until count events are recorded and return them sorted
+func (r *recorder) wait(t *testing.T, count int) []string {
+	for i := 0; i < 500; i++ {
+		r.mu.Lock()
+		n := len(r.events)
+		r.mu.Unlock()
+		if n >= count {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// later events, which are not expected, have a chance to show up
+	time.Sleep(50 * time.Millisecond)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := append([]string{}, r.events...)
+	sort.Strings(events)
+	return events
+}
+
+func configMap(namespace string, name string, labels map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}}
+}
+
+func TestConfigMapController(t *testing.T) {
+	managed := map[string]string{"alertmanager.net/managed": "true"}
+	cases := []struct {
+		name          string
+		namespaces    []string
+		labelSelector string
+		want          []string
+	}{
+		{
+			name: "all namespaces",
+			want: []string{"create team-a/managed", "create team-a/unmanaged", "create team-b/managed", "create team-c/managed"},
+		},
+		{
+			name:       "namespace list",
+			namespaces: []string{"team-a", "team-c"},
+			want:       []string{"create team-a/managed", "create team-a/unmanaged", "create team-c/managed"},
+		},
+		{
+			name:          "label selector",
+			labelSelector: "alertmanager.net/managed=true",
+			want:          []string{"create team-a/managed", "create team-b/managed", "create team-c/managed"},
+		},
+		{
+			name:          "namespace list and label selector",
+			namespaces:    []string{"team-b"},
+			labelSelector: "alertmanager.net/managed=true",
+			want:          []string{"create team-b/managed"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(
+				configMap("team-a", "managed", managed),
+				configMap("team-a", "unmanaged", nil),
+				configMap("team-b", "managed", managed),
+				configMap("team-c", "managed", managed),
+			)
+			r := &recorder{}
+			cc := &ConfigMapController{Controller: r, Namespaces: tc.namespaces, LabelSelector: tc.labelSelector}
+			cc.Initialize(client)
+			stop := make(chan struct{})
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			go cc.Run(stop, wg)
+			defer close(stop)
+
+			got := r.wait(t, len(tc.want))
+			if len(got) != len(tc.want) {
+				t.Fatalf("events = %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("events = %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigMapControllerDelete(t *testing.T) {
+	cases := []struct {
+		name string
+		obj  interface{}
+		want []string
+	}{
+		{"configmap", configMap("team-a", "r", nil), []string{"delete team-a/r"}},
+		{"tombstone", cache.DeletedFinalStateUnknown{Key: "team-a/r", Obj: configMap("team-a", "r", nil)}, []string{"delete team-a/r"}},
+		{"tombstone of an unknown object", cache.DeletedFinalStateUnknown{Key: "team-a/r", Obj: "r"}, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &recorder{}
+			cc := &ConfigMapController{Controller: r}
+			cc.delete(tc.obj)
+			if len(r.events) != len(tc.want) || (len(tc.want) > 0 && r.events[0] != tc.want[0]) {
+				t.Errorf("events = %v, want %v", r.events, tc.want)
+			}
+		})
+	}
+}
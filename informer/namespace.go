@@ -0,0 +1,97 @@
+package informer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dbsystel/kube-controller-dbsystel-go-common/controller"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NamespaceController watches all namespaces, or only Namespaces if it is set
+type NamespaceController struct {
+	Controller controller.Controller
+	Namespaces []string
+	informer   cache.SharedIndexInformer
+}
+
+// Run the informer until stopCh is closed, the caller adds it to wg before starting it
+func (nc *NamespaceController) Run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	go nc.informer.Run(stopCh)
+	<-stopCh
+}
+
+// HasSynced tells whether the informer listed the namespaces
+func (nc *NamespaceController) HasSynced() bool {
+	return nc.informer.HasSynced()
+}
+
+// List the watched namespaces in the cache of the informer
+func (nc *NamespaceController) List() []interface{} {
+	var objs []interface{}
+	for _, obj := range nc.informer.GetStore().List() {
+		if nc.watched(obj) {
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
+
+// Initialize the informer of namespaces
+func (nc *NamespaceController) Initialize(kclient kubernetes.Interface) {
+	nc.informer = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return kclient.CoreV1().Namespaces().List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return kclient.CoreV1().Namespaces().Watch(options)
+			},
+		},
+		&v1.Namespace{},
+		3*time.Minute,
+		cache.Indexers{},
+	)
+
+	nc.informer.AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: nc.watched,
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    nc.Controller.Create,
+			UpdateFunc: nc.Controller.Update,
+			DeleteFunc: nc.delete,
+		},
+	})
+}
+
+func (nc *NamespaceController) watched(obj interface{}) bool {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	namespace, ok := obj.(*v1.Namespace)
+	if !ok {
+		return false
+	}
+	if len(nc.Namespaces) == 0 {
+		return true
+	}
+	for _, n := range nc.Namespaces {
+		if n == namespace.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (nc *NamespaceController) delete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	nc.Controller.Delete(obj)
+}
@@ -0,0 +1,145 @@
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Resource types a policy can be defined for, named like their annotations
+var (
+	Route       = "route"
+	Receiver    = "receiver"
+	InhibitRule = "inhibit_rule"
+	Config      = "config"
+)
+
+// Policy restricts which configmaps the controller admits
+type Policy struct {
+	Namespaces   map[string]NamespaceRule `yaml:"namespaces"`
+	Enforcement  string                   `yaml:"enforcement"`
+	Routes       *RouteRule               `yaml:"routes"`
+	Receivers    *ReceiverRule            `yaml:"receivers"`
+	InhibitRules *InhibitRuleRule         `yaml:"inhibit_rules"`
+	Quotas       *Quotas                  `yaml:"quotas"`
+	Config       *ConfigRule              `yaml:"config"`
+}
+
+// NamespaceRule lists namespaces allowed or denied to contribute a resource type,
+// entries may contain shell patterns like team-*
+type NamespaceRule struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// ConfigRule restricts who may replace the config template,
+// entries may contain shell patterns like helm*
+type ConfigRule struct {
+	Managers []string `yaml:"managers"`
+}
+
+// Load reads a policy from a YAML file
+func Load(file string) (*Policy, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	err = yaml.UnmarshalStrict(content, p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %s", file, err)
+	}
+	err = p.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %s", file, err)
+	}
+	return p, nil
+}
+
+// Validate checks a policy and fills in its defaults
+func (p *Policy) Validate() error {
+	if p.Enforcement == "" {
+		p.Enforcement = Reject
+	}
+	if p.Enforcement != Reject && p.Enforcement != Clamp {
+		return fmt.Errorf("unknown enforcement %q", p.Enforcement)
+	}
+	for resource, rule := range p.Namespaces {
+		if resource != Route && resource != Receiver && resource != InhibitRule && resource != Config {
+			return fmt.Errorf("unknown resource type %q", resource)
+		}
+		for _, pattern := range append(rule.Allow, rule.Deny...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("bad namespace pattern %q", pattern)
+			}
+		}
+	}
+	if p.Routes != nil {
+		for _, pattern := range append(p.Routes.ExemptNamespaces, p.Routes.StopNamespaces...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("bad namespace pattern %q", pattern)
+			}
+		}
+	}
+	if p.Config != nil {
+		for _, pattern := range p.Config.Managers {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("bad manager pattern %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// AllowManagers returns an error, if one of the managers of a config configmap is not allowed to write it
+func (p *Policy) AllowManagers(managers []string) error {
+	if p == nil || p.Config == nil || len(p.Config.Managers) == 0 {
+		return nil
+	}
+	if len(managers) == 0 {
+		return fmt.Errorf("managers of the config are unknown, allowed are %v", p.Config.Managers)
+	}
+	for _, manager := range managers {
+		if !matchAny(p.Config.Managers, manager) {
+			return fmt.Errorf("config is managed by %s, allowed are %v", manager, p.Config.Managers)
+		}
+	}
+	return nil
+}
+
+// AllowStop reports whether routes of namespace may stop the evaluation of following routes
+func (p *Policy) AllowStop(namespace string) bool {
+	if p == nil || p.Routes == nil {
+		return false
+	}
+	return matchAny(p.Routes.StopNamespaces, namespace)
+}
+
+// AllowNamespace returns an error, if namespace may not contribute the resource type
+func (p *Policy) AllowNamespace(resource string, namespace string) error {
+	if p == nil {
+		return nil
+	}
+	rule, ok := p.Namespaces[resource]
+	if !ok {
+		return nil
+	}
+	if matchAny(rule.Deny, namespace) {
+		return fmt.Errorf("namespace %s is denied to contribute %s", namespace, resource)
+	}
+	if len(rule.Allow) > 0 && !matchAny(rule.Allow, namespace) {
+		return fmt.Errorf("namespace %s is not allowed to contribute %s", namespace, resource)
+	}
+	return nil
+}
+
+func matchAny(patterns []string, namespace string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
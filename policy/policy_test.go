@@ -0,0 +1,143 @@
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		// error contains, empty if the policy is valid
+		err string
+	}{
+		{"empty policy", "", ""},
+		{"namespace lists", "namespaces:\n  route:\n    allow: [team-*]\n    deny: [team-legacy]\n", ""},
+		{"clamp", "enforcement: clamp\n", ""},
+		{"unknown enforcement", "enforcement: warn\n", `unknown enforcement "warn"`},
+		{"unknown resource type", "namespaces:\n  silence:\n    allow: [team-*]\n", `unknown resource type "silence"`},
+		{"bad namespace pattern", "namespaces:\n  receiver:\n    deny: ['team-[']\n", `bad namespace pattern "team-["`},
+		{"unknown field", "namespace:\n  route: {}\n", "field namespace not found"},
+		{"bad manager pattern", "config:\n  managers: ['[']\n", `bad manager pattern "["`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "policy")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			file := filepath.Join(dir, "policy.yaml")
+			if err = ioutil.WriteFile(file, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			p, err := Load(file)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("Load = %v", err)
+				}
+				if p.Enforcement == "" {
+					t.Errorf("enforcement has no default")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Load = %v, want error %q", err, tc.err)
+			}
+		})
+	}
+
+	if _, err := Load("/does/not/exist"); err == nil {
+		t.Errorf("Load of a missing file succeeded")
+	}
+}
+
+func TestAllowNamespace(t *testing.T) {
+	p := &Policy{Namespaces: map[string]NamespaceRule{
+		Route:    {Allow: []string{"team-*", "monitoring"}, Deny: []string{"team-legacy"}},
+		Receiver: {Deny: []string{"kube-*"}},
+	}}
+	cases := []struct {
+		policy    *Policy
+		resource  string
+		namespace string
+		// error contains, empty if allowed
+		err string
+	}{
+		{p, Route, "team-a", ""},
+		{p, Route, "monitoring", ""},
+		{p, Route, "team-legacy", "namespace team-legacy is denied to contribute route"},
+		{p, Route, "default", "namespace default is not allowed to contribute route"},
+		{p, Receiver, "default", ""},
+		{p, Receiver, "kube-system", "namespace kube-system is denied to contribute receiver"},
+		{p, InhibitRule, "kube-system", ""},
+		{nil, Route, "kube-system", ""},
+	}
+	for _, tc := range cases {
+		err := tc.policy.AllowNamespace(tc.resource, tc.namespace)
+		if tc.err == "" && err != nil {
+			t.Errorf("AllowNamespace(%s, %s) = %v, want allowed", tc.resource, tc.namespace, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("AllowNamespace(%s, %s) = %v, want %q", tc.resource, tc.namespace, err, tc.err)
+		}
+	}
+}
+
+func TestAllowManagers(t *testing.T) {
+	p := &Policy{Config: &ConfigRule{Managers: []string{"helm", "argocd-*"}}}
+	cases := []struct {
+		name     string
+		policy   *Policy
+		managers []string
+		// error contains, empty if allowed
+		err string
+	}{
+		{"no policy", nil, nil, ""},
+		{"no managers configured", &Policy{Config: &ConfigRule{}}, []string{"kubectl"}, ""},
+		{"allowed manager", p, []string{"helm"}, ""},
+		{"pattern", p, []string{"argocd-controller", "helm"}, ""},
+		{"other manager", p, []string{"helm", "kubectl-edit"}, "config is managed by kubectl-edit, allowed are [helm argocd-*]"},
+		{"unknown managers", p, nil, "managers of the config are unknown"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.AllowManagers(tc.managers)
+			if tc.err == "" && err != nil {
+				t.Errorf("AllowManagers(%v) = %v, want allowed", tc.managers, err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("AllowManagers(%v) = %v, want %q", tc.managers, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestAllowStop(t *testing.T) {
+	p := &Policy{Routes: &RouteRule{StopNamespaces: []string{"monitoring", "platform-*"}}}
+	cases := []struct {
+		name      string
+		policy    *Policy
+		namespace string
+		want      bool
+	}{
+		{"no policy", nil, "monitoring", false},
+		{"no route rule", &Policy{}, "monitoring", false},
+		{"no stop namespaces", &Policy{Routes: &RouteRule{}}, "monitoring", false},
+		{"allowed namespace", p, "monitoring", true},
+		{"pattern", p, "platform-logging", true},
+		{"other namespace", p, "team-a", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.policy.AllowStop(tc.namespace); got != tc.want {
+				t.Errorf("AllowStop(%q) = %v, want %v", tc.namespace, got, tc.want)
+			}
+		})
+	}
+}
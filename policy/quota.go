@@ -0,0 +1,58 @@
+package policy
+
+import (
+	"fmt"
+)
+
+// Quotas limit the fragments namespaces may contribute
+type Quotas struct {
+	// Default applies to every namespace without an own quota
+	Default *Quota `yaml:"default"`
+	// Namespaces with an own quota
+	Namespaces map[string]Quota `yaml:"namespaces"`
+	// Global applies to the fragments of all namespaces together
+	Global *Quota `yaml:"global"`
+}
+
+// Quota of fragments and their total size, zero means unlimited
+type Quota struct {
+	MaxFragments int `yaml:"max_fragments"`
+	MaxBytes     int `yaml:"max_bytes"`
+}
+
+// Usage of fragments and their total size
+type Usage struct {
+	Fragments int
+	Bytes     int
+}
+
+// CheckQuota returns an error, if the usage of namespace or of all namespaces exceeds the quotas
+func (p *Policy) CheckQuota(namespace string, usage Usage, global Usage) error {
+	if p == nil || p.Quotas == nil {
+		return nil
+	}
+	quota := p.Quotas.Default
+	if q, ok := p.Quotas.Namespaces[namespace]; ok {
+		quota = &q
+	}
+	if err := quota.check(usage); err != nil {
+		return fmt.Errorf("namespace %s %s", namespace, err)
+	}
+	if err := p.Quotas.Global.check(global); err != nil {
+		return fmt.Errorf("all namespaces %s", err)
+	}
+	return nil
+}
+
+func (q *Quota) check(usage Usage) error {
+	if q == nil {
+		return nil
+	}
+	if q.MaxFragments > 0 && usage.Fragments > q.MaxFragments {
+		return fmt.Errorf("would have %d fragments, the limit is %d", usage.Fragments, q.MaxFragments)
+	}
+	if q.MaxBytes > 0 && usage.Bytes > q.MaxBytes {
+		return fmt.Errorf("would have %d bytes of fragments, the limit is %d", usage.Bytes, q.MaxBytes)
+	}
+	return nil
+}
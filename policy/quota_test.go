@@ -0,0 +1,42 @@
+package policy
+
+import (
+	"testing"
+)
+
+func TestCheckQuota(t *testing.T) {
+	p := &Policy{Quotas: &Quotas{
+		Default:    &Quota{MaxFragments: 2, MaxBytes: 100},
+		Namespaces: map[string]Quota{"monitoring": {MaxFragments: 5}},
+		Global:     &Quota{MaxFragments: 6},
+	}}
+	cases := []struct {
+		name      string
+		policy    *Policy
+		namespace string
+		usage     Usage
+		global    Usage
+		// error, empty if the usage is within the quotas
+		err string
+	}{
+		{"no policy", nil, "team-a", Usage{100, 10000}, Usage{100, 10000}, ""},
+		{"no quotas", &Policy{}, "team-a", Usage{100, 10000}, Usage{100, 10000}, ""},
+		{"within the default quota", p, "team-a", Usage{2, 100}, Usage{2, 100}, ""},
+		{"too many fragments", p, "team-a", Usage{3, 30}, Usage{3, 30}, "namespace team-a would have 3 fragments, the limit is 2"},
+		{"too many bytes", p, "team-a", Usage{1, 101}, Usage{1, 101}, "namespace team-a would have 101 bytes of fragments, the limit is 100"},
+		{"own quota of a namespace", p, "monitoring", Usage{5, 1000}, Usage{5, 1000}, ""},
+		{"global quota", p, "monitoring", Usage{5, 1000}, Usage{7, 1000}, "all namespaces would have 7 fragments, the limit is 6"},
+		{"unlimited", &Policy{Quotas: &Quotas{Default: &Quota{}}}, "team-a", Usage{100, 10000}, Usage{100, 10000}, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.CheckQuota(tc.namespace, tc.usage, tc.global)
+			if tc.err == "" && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if tc.err != "" && (err == nil || err.Error() != tc.err) {
+				t.Errorf("error = %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
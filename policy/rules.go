@@ -0,0 +1,247 @@
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// Enforcement modes of rules
+var (
+	Reject = "reject"
+	Clamp  = "clamp"
+)
+
+// RouteRule limits the timing of routes, including nested routes
+type RouteRule struct {
+	MinGroupWait      model.Duration `yaml:"min_group_wait"`
+	MinGroupInterval  model.Duration `yaml:"min_group_interval"`
+	MinRepeatInterval model.Duration `yaml:"min_repeat_interval"`
+	ExemptNamespaces  []string       `yaml:"exempt_namespaces"`
+	// StopNamespaces may set continue: false on their top-level routes, all other routes always continue
+	StopNamespaces []string `yaml:"stop_namespaces"`
+}
+
+// ReceiverRule limits the integrations receivers may use
+type ReceiverRule struct {
+	AllowedTypes     []string `yaml:"allowed_types"`
+	ExemptNamespaces []string `yaml:"exempt_namespaces"`
+}
+
+// InhibitRuleRule enforces labels inhibit rules have to match on
+type InhibitRuleRule struct {
+	RequiredEqual    []string `yaml:"required_equal"`
+	ExemptNamespaces []string `yaml:"exempt_namespaces"`
+}
+
+// Result of evaluating a fragment against the rules
+type Result struct {
+	// Content of the fragment, corrected if rules are clamped
+	Content string
+	// Violations which could not be corrected
+	Violations []string
+	// Corrections applied to the content
+	Corrections []string
+}
+
+// Evaluate checks the content of a fragment from namespace against the rules of its resource type.
+// Clamped values are corrected in place, comments and formatting of the fragment are kept.
+func (p *Policy) Evaluate(resource string, namespace string, content string) (*Result, error) {
+	result := &Result{Content: content}
+	if p == nil || !p.hasRule(resource, namespace) {
+		return result, nil
+	}
+
+	var doc yaml.Node
+	err := yaml.Unmarshal([]byte(content), &doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return result, nil
+	}
+	items := resolve(doc.Content[0])
+	if items.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("line %d: %s is not a list", items.Line, resource)
+	}
+
+	clamp := p.Enforcement == Clamp
+	for _, item := range items.Content {
+		item = resolve(item)
+		if item.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("line %d: %s is not a mapping", item.Line, resource)
+		}
+		switch resource {
+		case Route:
+			p.Routes.check(item, clamp, result)
+		case Receiver:
+			p.Receivers.check(item, result)
+		case InhibitRule:
+			p.InhibitRules.check(item, clamp, result)
+		}
+	}
+
+	if len(result.Corrections) > 0 {
+		var out bytes.Buffer
+		encoder := yaml.NewEncoder(&out)
+		encoder.SetIndent(2)
+		if err = encoder.Encode(&doc); err != nil {
+			return nil, err
+		}
+		if err = encoder.Close(); err != nil {
+			return nil, err
+		}
+		result.Content = out.String()
+	}
+	return result, nil
+}
+
+// is there a rule for the resource type, which applies to namespace
+func (p *Policy) hasRule(resource string, namespace string) bool {
+	switch resource {
+	case Route:
+		return p.Routes != nil && !matchAny(p.Routes.ExemptNamespaces, namespace)
+	case Receiver:
+		return p.Receivers != nil && !matchAny(p.Receivers.ExemptNamespaces, namespace)
+	case InhibitRule:
+		return p.InhibitRules != nil && !matchAny(p.InhibitRules.ExemptNamespaces, namespace)
+	default:
+		return false
+	}
+}
+
+func (r *RouteRule) check(route *yaml.Node, clamp bool, result *Result) {
+	minimums := []struct {
+		key string
+		min model.Duration
+	}{
+		{"group_wait", r.MinGroupWait},
+		{"group_interval", r.MinGroupInterval},
+		{"repeat_interval", r.MinRepeatInterval},
+	}
+	for i := 0; i+1 < len(route.Content); i += 2 {
+		key := route.Content[i].Value
+		value := resolve(route.Content[i+1])
+		for _, m := range minimums {
+			if key != m.key || m.min == 0 {
+				continue
+			}
+			d, err := model.ParseDuration(value.Value)
+			if err != nil || value.Kind != yaml.ScalarNode {
+				result.Violations = append(result.Violations, fmt.Sprintf("%s %q is not a duration", key, value.Value))
+				continue
+			}
+			if time.Duration(d) >= time.Duration(m.min) {
+				continue
+			}
+			if clamp {
+				result.Corrections = append(result.Corrections, fmt.Sprintf("%s raised from %s to %s", key, value.Value, m.min))
+				setScalar(value, m.min.String())
+			} else {
+				result.Violations = append(result.Violations, fmt.Sprintf("%s %s is below %s", key, value.Value, m.min))
+			}
+		}
+		if key == "routes" && value.Kind == yaml.SequenceNode {
+			for _, child := range value.Content {
+				if child = resolve(child); child.Kind == yaml.MappingNode {
+					r.check(child, clamp, result)
+				}
+			}
+		}
+	}
+}
+
+func (r *ReceiverRule) check(receiver *yaml.Node, result *Result) {
+	if len(r.AllowedTypes) == 0 {
+		return
+	}
+	name := ""
+	if value := mappingValue(receiver, "name"); value != nil {
+		name = value.Value
+	}
+	for i := 0; i+1 < len(receiver.Content); i += 2 {
+		key := receiver.Content[i].Value
+		if key == "name" || contains(r.AllowedTypes, key) {
+			continue
+		}
+		result.Violations = append(result.Violations, fmt.Sprintf("receiver %s uses %s, allowed are %v", name, key, r.AllowedTypes))
+	}
+}
+
+func (r *InhibitRuleRule) check(rule *yaml.Node, clamp bool, result *Result) {
+	equal := mappingValue(rule, "equal")
+	var labels []string
+	if equal != nil && equal.Kind == yaml.SequenceNode {
+		for _, e := range equal.Content {
+			labels = append(labels, resolve(e).Value)
+		}
+	}
+	var missing []string
+	for _, label := range r.RequiredEqual {
+		if contains(labels, label) {
+			continue
+		}
+		if clamp {
+			missing = append(missing, label)
+			result.Corrections = append(result.Corrections, fmt.Sprintf("%s added to equal", label))
+		} else {
+			result.Violations = append(result.Violations, fmt.Sprintf("inhibit rule does not match on equal %s", label))
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	if equal == nil {
+		equal = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		rule.Content = append(rule.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "equal"}, equal)
+	} else if equal.Kind != yaml.SequenceNode {
+		// a single label or null is replaced by a list
+		*equal = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: scalars(labels), LineComment: equal.LineComment}
+	}
+	equal.Content = append(equal.Content, scalars(missing)...)
+}
+
+// the node an alias points to, any other node itself
+func resolve(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		return node.Alias
+	}
+	return node
+}
+
+// the value of key in a mapping node, nil if it is missing
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return resolve(mapping.Content[i+1])
+		}
+	}
+	return nil
+}
+
+// replace the value of a scalar node, it is written plain
+func setScalar(node *yaml.Node, value string) {
+	node.Tag = "!!str"
+	node.Value = value
+	node.Style = 0
+}
+
+func scalars(values []string) []*yaml.Node {
+	var nodes []*yaml.Node
+	for _, v := range values {
+		nodes = append(nodes, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v})
+	}
+	return nodes
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
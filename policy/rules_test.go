@@ -0,0 +1,175 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestEvaluate(t *testing.T) {
+	routes := &RouteRule{
+		MinGroupWait:      model.Duration(30 * time.Second),
+		MinRepeatInterval: model.Duration(time.Hour),
+		ExemptNamespaces:  []string{"monitoring"},
+	}
+	receivers := &ReceiverRule{AllowedTypes: []string{"webhook_configs", "email_configs"}}
+	inhibitRules := &InhibitRuleRule{RequiredEqual: []string{"namespace"}}
+	reject := &Policy{Enforcement: Reject, Routes: routes, Receivers: receivers, InhibitRules: inhibitRules}
+	clamp := &Policy{Enforcement: Clamp, Routes: routes, Receivers: receivers, InhibitRules: inhibitRules}
+
+	cases := []struct {
+		name      string
+		policy    *Policy
+		resource  string
+		namespace string
+		content   string
+		// corrected content, the content itself if empty
+		want        string
+		violations  []string
+		corrections []string
+		err         string
+	}{
+		{
+			name:     "route within the limits",
+			policy:   reject,
+			resource: Route,
+			content:  "- receiver: a\n  group_wait: 1m\n",
+		},
+		{
+			name:       "route below the limits is rejected",
+			policy:     reject,
+			resource:   Route,
+			content:    "- receiver: a\n  group_wait: 10s\n  routes:\n  - receiver: b\n    repeat_interval: 5m\n",
+			violations: []string{"group_wait 10s is below 30s", "repeat_interval 5m is below 1h"},
+		},
+		{
+			name:      "exempt namespace",
+			policy:    reject,
+			resource:  Route,
+			namespace: "monitoring",
+			content:   "- receiver: a\n  group_wait: 1s\n",
+		},
+		{
+			name:       "invalid duration",
+			policy:     reject,
+			resource:   Route,
+			content:    "- receiver: a\n  group_wait: soon\n",
+			violations: []string{`group_wait "soon" is not a duration`},
+		},
+		{
+			name:     "clamped route keeps comments and formatting",
+			policy:   clamp,
+			resource: Route,
+			content: "# priority: 10\n- receiver: a # team a\n  group_wait: \"10s\" # too short\n  match: {team: a}\n" +
+				"  routes:\n  - receiver: b\n    repeat_interval: 5m\n",
+			want: "# priority: 10\n- receiver: a # team a\n  group_wait: 30s # too short\n  match: {team: a}\n" +
+				"  routes:\n    - receiver: b\n      repeat_interval: 1h\n",
+			corrections: []string{"group_wait raised from 10s to 30s", "repeat_interval raised from 5m to 1h"},
+		},
+		{
+			name:     "clamped alias",
+			policy:   clamp,
+			resource: Route,
+			content:  "- receiver: a\n  group_wait: &wait 10s\n- receiver: b\n  group_wait: *wait\n",
+			want:     "- receiver: a\n  group_wait: &wait 30s\n- receiver: b\n  group_wait: *wait\n",
+			// the second route sees the value already raised
+			corrections: []string{"group_wait raised from 10s to 30s"},
+		},
+		{
+			name:       "receiver with a type not allowed",
+			policy:     clamp,
+			resource:   Receiver,
+			content:    "- name: pager\n  pagerduty_configs:\n  - service_key: x\n  webhook_configs:\n  - url: http://x\n",
+			violations: []string{"receiver pager uses pagerduty_configs, allowed are [webhook_configs email_configs]"},
+		},
+		{
+			name:       "inhibit rule without the required equal",
+			policy:     reject,
+			resource:   InhibitRule,
+			content:    "- source_match:\n    severity: critical\n  equal: [alertname]\n",
+			violations: []string{"inhibit rule does not match on equal namespace"},
+		},
+		{
+			name:        "clamped inhibit rule",
+			policy:      clamp,
+			resource:    InhibitRule,
+			content:     "- source_match:\n    severity: critical\n  # the same alert\n  equal: [alertname]\n",
+			want:        "- source_match:\n    severity: critical\n  # the same alert\n  equal: [alertname, namespace]\n",
+			corrections: []string{"namespace added to equal"},
+		},
+		{
+			name:        "clamped inhibit rule without equal",
+			policy:      clamp,
+			resource:    InhibitRule,
+			content:     "- source_match:\n    severity: critical\n",
+			want:        "- source_match:\n    severity: critical\n  equal:\n    - namespace\n",
+			corrections: []string{"namespace added to equal"},
+		},
+		{
+			name:     "empty fragment",
+			policy:   reject,
+			resource: Route,
+			content:  "",
+		},
+		{
+			name:     "no rule for the resource type",
+			policy:   &Policy{Enforcement: Reject},
+			resource: Route,
+			content:  "not: [yaml",
+		},
+		{
+			name:     "invalid YAML",
+			policy:   reject,
+			resource: Route,
+			content:  "- receiver: [a\n",
+			err:      "yaml:",
+		},
+		{
+			name:     "not a list",
+			policy:   reject,
+			resource: Route,
+			content:  "receiver: a\n",
+			err:      "line 1: route is not a list",
+		},
+		{
+			name:     "not a mapping",
+			policy:   reject,
+			resource: Receiver,
+			content:  "- a\n",
+			err:      "line 1: receiver is not a mapping",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			namespace := tc.namespace
+			if namespace == "" {
+				namespace = "team-a"
+			}
+			result, err := tc.policy.Evaluate(tc.resource, namespace, tc.content)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Evaluate = %v, want error %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tc.want
+			if want == "" {
+				want = tc.content
+			}
+			if result.Content != want {
+				t.Errorf("content =\n%s\nwant\n%s", result.Content, want)
+			}
+			if strings.Join(result.Violations, "; ") != strings.Join(tc.violations, "; ") {
+				t.Errorf("violations = %q, want %q", result.Violations, tc.violations)
+			}
+			if strings.Join(result.Corrections, "; ") != strings.Join(tc.corrections, "; ") {
+				t.Errorf("corrections = %q, want %q", result.Corrections, tc.corrections)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package setup
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	configLoaded = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "alertmanager_config_controller_config_last_reload_successful",
+			Help: "Whether the last reload of the controller config file was successful.",
+		},
+	)
+	configLoadedTime = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "alertmanager_config_controller_config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful reload of the controller config file.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(configLoaded)
+	prometheus.MustRegister(configLoadedTime)
+}
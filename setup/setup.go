@@ -0,0 +1,197 @@
+package setup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dbsystel/alertmanager-config-controller/policy"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Config of a controller serving several Alertmanager setups
+type Config struct {
+	WatchNamespaces []string       `yaml:"watch_namespaces"`
+	LabelSelector   string         `yaml:"label_selector"`
+	ListenAddress   string         `yaml:"listen_address"`
+	LeaderElection  LeaderElection `yaml:"leader_election"`
+	Setups          []Setup        `yaml:"setups"`
+}
+
+// LeaderElection between several replicas of the controller
+type LeaderElection struct {
+	Enabled       bool          `yaml:"enabled"`
+	Namespace     string        `yaml:"namespace"`
+	Name          string        `yaml:"name"`
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+	RenewDeadline time.Duration `yaml:"renew_deadline"`
+	RetryPeriod   time.Duration `yaml:"retry_period"`
+}
+
+// Setup is a single Alertmanager setup served by the controller
+type Setup struct {
+	ID              string            `yaml:"id"`
+	Key             string            `yaml:"key"`
+	KeyFile         string            `yaml:"key_file"`
+	AllowPlainKey   bool              `yaml:"allow_plain_key"`
+	ConfigPath      string            `yaml:"config_path"`
+	ConfigTemplate  string            `yaml:"config_template"`
+	ReloadURL       string            `yaml:"reload_url"`
+	HistoryLimit    int               `yaml:"history_limit"`
+	PolicyFile      string            `yaml:"policy_file"`
+	Policy          *policy.Policy    `yaml:"policy"`
+	Output          string            `yaml:"output"`
+	OutputNamespace string            `yaml:"output_namespace"`
+	OutputName      string            `yaml:"output_name"`
+	OutputKey       string            `yaml:"output_key"`
+	Substitute      bool              `yaml:"substitute"`
+	SubstituteEnv   []string          `yaml:"substitute_env"`
+	ValuesFile      string            `yaml:"values_file"`
+	Vars            map[string]string `yaml:"vars"`
+	NamespaceRoutes bool              `yaml:"namespace_routes"`
+	// Values of the variables in fragments, taken from substitute_env, values_file and vars
+	Values map[string]string `yaml:"-"`
+}
+
+// Load reads the controller config from a YAML file, policy files of setups are read along with it
+func Load(file string) (*Config, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	err = yaml.UnmarshalStrict(content, c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid controller config %s: %s", file, err)
+	}
+	if len(c.Setups) == 0 {
+		return nil, fmt.Errorf("invalid controller config %s: no setups defined", file)
+	}
+	if _, err = labels.Parse(c.LabelSelector); err != nil {
+		return nil, fmt.Errorf("invalid controller config %s: label_selector: %s", file, err)
+	}
+	for _, namespace := range c.WatchNamespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid controller config %s: watch_namespaces: %s", file, strings.Join(errs, "; "))
+		}
+	}
+	c.LeaderElection.defaults()
+	ids := make(map[string]bool)
+	paths := make(map[string]bool)
+	for i := range c.Setups {
+		s := &c.Setups[i]
+		if reason := ValidateID(s.ID); reason != "" {
+			return nil, fmt.Errorf("invalid controller config %s: setup %q: %s", file, s.ID, reason)
+		}
+		if ids[s.ID] {
+			return nil, fmt.Errorf("invalid controller config %s: setup %q defined twice", file, s.ID)
+		}
+		ids[s.ID] = true
+		if s.ConfigPath == "" || s.ConfigTemplate == "" {
+			return nil, fmt.Errorf("invalid controller config %s: setup %q needs config_path and config_template", file, s.ID)
+		}
+		if paths[s.ConfigPath] {
+			return nil, fmt.Errorf("invalid controller config %s: setup %q shares config_path %s with another setup", file, s.ID, s.ConfigPath)
+		}
+		paths[s.ConfigPath] = true
+		s.defaults()
+		if err = s.LoadKey(); err != nil {
+			return nil, fmt.Errorf("invalid controller config %s: setup %q: %s", file, s.ID, err)
+		}
+		if err = s.LoadValues(); err != nil {
+			return nil, fmt.Errorf("invalid controller config %s: setup %q: %s", file, s.ID, err)
+		}
+		if s.Output != "file" && s.Output != "secret" && s.Output != "configmap" {
+			return nil, fmt.Errorf("invalid controller config %s: setup %q: unknown output %q", file, s.ID, s.Output)
+		}
+		if s.ReloadURL != "" {
+			if _, err = url.Parse(s.ReloadURL); err != nil {
+				return nil, fmt.Errorf("invalid controller config %s: setup %q: reload_url: %s", file, s.ID, err)
+			}
+		}
+		switch {
+		case s.PolicyFile != "" && s.Policy != nil:
+			return nil, fmt.Errorf("invalid controller config %s: setup %q: either policy_file or policy may be set", file, s.ID)
+		case s.PolicyFile != "":
+			s.Policy, err = policy.Load(s.PolicyFile)
+			if err != nil {
+				return nil, fmt.Errorf("invalid controller config %s: setup %q: %s", file, s.ID, err)
+			}
+		case s.Policy != nil:
+			err = s.Policy.Validate()
+			if err != nil {
+				return nil, fmt.Errorf("invalid controller config %s: setup %q: invalid policy: %s", file, s.ID, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// LoadKey reads the key of the setup from its key file, if there is one
+func (s *Setup) LoadKey() error {
+	if s.KeyFile == "" {
+		return nil
+	}
+	if s.Key != "" {
+		return fmt.Errorf("either key or key_file may be set")
+	}
+	content, err := ioutil.ReadFile(s.KeyFile)
+	if err != nil {
+		return err
+	}
+	s.Key = strings.TrimSpace(string(content))
+	if s.Key == "" {
+		return fmt.Errorf("key file %s is empty", s.KeyFile)
+	}
+	return nil
+}
+
+// fill in the defaults of the command line flags
+func (s *Setup) defaults() {
+	if s.HistoryLimit == 0 {
+		s.HistoryLimit = 10
+	}
+	if s.Output == "" {
+		s.Output = "file"
+	}
+	if s.OutputNamespace == "" {
+		s.OutputNamespace = "default"
+	}
+	if s.OutputName == "" {
+		s.OutputName = "alertmanager-config"
+	}
+	if s.OutputKey == "" {
+		s.OutputKey = "alertmanager.yml"
+	}
+}
+
+// fill in the defaults of the command line flags
+func (l *LeaderElection) defaults() {
+	if l.Namespace == "" {
+		l.Namespace = "default"
+	}
+	if l.Name == "" {
+		l.Name = "alertmanager-config-controller"
+	}
+	if l.LeaseDuration == 0 {
+		l.LeaseDuration = 15 * time.Second
+	}
+	if l.RenewDeadline == 0 {
+		l.RenewDeadline = 10 * time.Second
+	}
+	if l.RetryPeriod == 0 {
+		l.RetryPeriod = 2 * time.Second
+	}
+}
+
+// ValidateID returns why id can not be used as the id of an Alertmanager setup, empty if it can
+func ValidateID(id string) string {
+	if id == "" {
+		return "id must not be empty"
+	}
+	return strings.Join(validation.IsValidLabelValue(id), "; ")
+}
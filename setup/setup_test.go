@@ -0,0 +1,150 @@
+package setup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateID(t *testing.T) {
+	cases := []struct {
+		id string
+		// reason contains, empty if the id is valid
+		reason string
+	}{
+		{"0", ""},
+		{"12", ""},
+		{"platform", ""},
+		{"team-payments", ""},
+		{"team_payments.eu", ""},
+		{"", "id must not be empty"},
+		{"team payments", "consist of alphanumeric characters"},
+		{"-platform", "consist of alphanumeric characters"},
+		{"a23456789012345678901234567890123456789012345678901234567890abcd", "must be no more than 63 characters"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.id, func(t *testing.T) {
+			reason := ValidateID(tc.id)
+			if tc.reason == "" && reason != "" {
+				t.Errorf("ValidateID(%q) = %q, want valid", tc.id, reason)
+			}
+			if tc.reason != "" && !strings.Contains(reason, tc.reason) {
+				t.Errorf("ValidateID(%q) = %q, want %q", tc.id, reason, tc.reason)
+			}
+		})
+	}
+}
+
+// write a file into dir and return its path
+func writeFile(t *testing.T, dir string, name string, content string) string {
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoad(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		// error contains, empty if the config is valid
+		err string
+	}{
+		{
+			name: "two setups",
+			content: `setups:
+- id: platform
+  config_path: /etc/platform
+  config_template: /etc/platform.tmpl
+- id: team-payments
+  config_path: /etc/team-payments
+  config_template: /etc/team-payments.tmpl
+  output: secret
+`,
+		},
+		{name: "no setups", content: "watch_namespaces: [team-a]\n", err: "no setups defined"},
+		{name: "unknown field", content: "setup: []\n", err: "field setup not found"},
+		{
+			name:    "invalid id",
+			content: "setups:\n- id: 'team payments'\n  config_path: /etc/a\n  config_template: /etc/a.tmpl\n",
+			err:     `setup "team payments": a valid label must be`,
+		},
+		{
+			name: "duplicate id",
+			content: `setups:
+- {id: platform, config_path: /etc/a, config_template: /etc/a.tmpl}
+- {id: platform, config_path: /etc/b, config_template: /etc/b.tmpl}
+`,
+			err: `setup "platform" defined twice`,
+		},
+		{
+			name: "shared config path",
+			content: `setups:
+- {id: platform, config_path: /etc/a, config_template: /etc/a.tmpl}
+- {id: team-payments, config_path: /etc/a, config_template: /etc/b.tmpl}
+`,
+			err: `setup "team-payments" shares config_path /etc/a with another setup`,
+		},
+		{name: "missing config path", content: "setups:\n- {id: platform, config_template: /etc/a.tmpl}\n", err: "needs config_path and config_template"},
+		{
+			name:    "unknown output",
+			content: "setups:\n- {id: platform, config_path: /etc/a, config_template: /etc/a.tmpl, output: s3}\n",
+			err:     `unknown output "s3"`,
+		},
+		{
+			name:    "invalid label selector",
+			content: "label_selector: 'team in (a'\nsetups:\n- {id: platform, config_path: /etc/a, config_template: /etc/a.tmpl}\n",
+			err:     "label_selector:",
+		},
+		{
+			name:    "invalid watch namespace",
+			content: "watch_namespaces: [Team_A]\nsetups:\n- {id: platform, config_path: /etc/a, config_template: /etc/a.tmpl}\n",
+			err:     "watch_namespaces:",
+		},
+		{
+			name: "policy file and inline policy",
+			content: `setups:
+- id: platform
+  config_path: /etc/a
+  config_template: /etc/a.tmpl
+  policy_file: /etc/policy.yaml
+  policy: {}
+`,
+			err: "either policy_file or policy may be set",
+		},
+		{
+			name:    "invalid inline policy",
+			content: "setups:\n- {id: platform, config_path: /etc/a, config_template: /etc/a.tmpl, policy: {enforcement: warn}}\n",
+			err:     "invalid policy:",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "setup")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			config, err := Load(writeFile(t, dir, "config.yaml", tc.content))
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if len(config.Setups) != 2 || config.Setups[1].Output != "secret" || config.Setups[0].Output != "file" {
+					t.Errorf("setups = %+v, want two setups with their outputs", config.Setups)
+				}
+				if config.LeaderElection.Name != "alertmanager-config-controller" || config.Setups[0].HistoryLimit != 10 {
+					t.Errorf("defaults were not filled in: %+v", config)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error = %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package setup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+// names of variables which can be used in fragments
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// LoadValues collects the values of the variables of the setup, vars win over the values file,
+// which wins over the environment
+func (s *Setup) LoadValues() error {
+	values := make(map[string]string)
+	for _, name := range s.SubstituteEnv {
+		if !variableName.MatchString(name) {
+			return fmt.Errorf("substitute_env: invalid variable name %q", name)
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			values[name] = v
+		}
+	}
+	if s.ValuesFile != "" {
+		content, err := ioutil.ReadFile(s.ValuesFile)
+		if err != nil {
+			return err
+		}
+		var file map[string]string
+		err = yaml.UnmarshalStrict(content, &file)
+		if err != nil {
+			return fmt.Errorf("invalid values file %s: %s", s.ValuesFile, err)
+		}
+		for name, v := range file {
+			if !variableName.MatchString(name) {
+				return fmt.Errorf("invalid values file %s: invalid variable name %q", s.ValuesFile, name)
+			}
+			values[name] = v
+		}
+	}
+	for name, v := range s.Vars {
+		if !variableName.MatchString(name) {
+			return fmt.Errorf("vars: invalid variable name %q", name)
+		}
+		values[name] = v
+	}
+	s.Values = values
+	return nil
+}
@@ -0,0 +1,93 @@
+package setup
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadValues(t *testing.T) {
+	cases := []struct {
+		name   string
+		env    []string
+		values string
+		vars   map[string]string
+		want   map[string]string
+		// error contains, empty if the values are valid
+		err string
+	}{
+		{
+			name: "environment",
+			env:  []string{"TEST_VALUES_ENV", "TEST_VALUES_UNSET"},
+			want: map[string]string{"TEST_VALUES_ENV": "env"},
+		},
+		{
+			name:   "values file wins over the environment",
+			env:    []string{"TEST_VALUES_ENV"},
+			values: "TEST_VALUES_ENV: file\nURL: http://file\n",
+			want:   map[string]string{"TEST_VALUES_ENV": "file", "URL": "http://file"},
+		},
+		{
+			name:   "vars win over the values file",
+			env:    []string{"TEST_VALUES_ENV"},
+			values: "TEST_VALUES_ENV: file\nURL: http://file\n",
+			vars:   map[string]string{"URL": "http://vars"},
+			want:   map[string]string{"TEST_VALUES_ENV": "file", "URL": "http://vars"},
+		},
+		{
+			name: "invalid environment variable",
+			env:  []string{"TEST-VALUES"},
+			err:  `substitute_env: invalid variable name "TEST-VALUES"`,
+		},
+		{
+			name:   "invalid name in the values file",
+			values: "1URL: http://file\n",
+			err:    `invalid variable name "1URL"`,
+		},
+		{
+			name:   "values file which is no map",
+			values: "- URL\n",
+			err:    "invalid values file",
+		},
+		{
+			name: "invalid name in vars",
+			vars: map[string]string{"A URL": "http://vars"},
+			err:  `vars: invalid variable name "A URL"`,
+		},
+	}
+	os.Setenv("TEST_VALUES_ENV", "env")
+	defer os.Unsetenv("TEST_VALUES_ENV")
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "values")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			s := &Setup{SubstituteEnv: tc.env, Vars: tc.vars}
+			if tc.values != "" {
+				s.ValuesFile = writeFile(t, dir, "values.yaml", tc.values)
+			}
+
+			err = s.LoadValues()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("LoadValues() = %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadValues() = %v", err)
+			}
+			if len(s.Values) != len(tc.want) {
+				t.Errorf("values = %v, want %v", s.Values, tc.want)
+			}
+			for k, v := range tc.want {
+				if s.Values[k] != v {
+					t.Errorf("value of %s = %q, want %q", k, s.Values[k], v)
+				}
+			}
+		})
+	}
+}
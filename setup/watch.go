@@ -0,0 +1,54 @@
+package setup
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Watch loads the controller config from file every interval, until stop is closed.
+// apply is called with every valid config differing from the last applied one and returns,
+// if the config could be applied.
+func Watch(file string, interval time.Duration, current *Config, apply func(*Config) error, logger log.Logger, stop <-chan struct{}) {
+	configLoaded.Set(1)
+	configLoadedTime.SetToCurrentTime()
+
+	lastErr := ""
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		config, err := Load(file)
+		if err == nil && reflect.DeepEqual(config, current) {
+			configLoaded.Set(1)
+			lastErr = ""
+			continue
+		}
+		if err == nil {
+			err = apply(config)
+		}
+		if err != nil {
+			configLoaded.Set(0)
+			// a broken config is only reported once, not on every interval
+			if err.Error() != lastErr {
+				//nolint:errcheck
+				level.Error(logger).Log("msg", "Failed to reload controller config, keeping the previous one", "file", file, "err", err.Error())
+			}
+			lastErr = err.Error()
+			continue
+		}
+		//nolint:errcheck
+		level.Info(logger).Log("msg", "Reloaded controller config", "file", file)
+		lastErr = ""
+		configLoaded.Set(1)
+		configLoadedTime.SetToCurrentTime()
+		current = config
+	}
+}
@@ -0,0 +1,98 @@
+package setup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// a controller config with a single setup
+func setupConfig(id string) string {
+	return "setups:\n- {id: " + id + ", config_path: /etc/" + id + ", config_template: /etc/" + id + ".tmpl}\n"
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := writeFile(t, dir, "config.yaml", setupConfig("platform"))
+	current, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied := make(chan *Config, 10)
+	failing := make(chan bool, 1)
+	failing <- false
+	apply := func(config *Config) error {
+		fail := <-failing
+		failing <- fail
+		if fail {
+			return fmt.Errorf("apply failed")
+		}
+		applied <- config
+		return nil
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		Watch(file, 10*time.Millisecond, current, apply, log.NewNopLogger(), stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	// the config applied next, nil if none is applied for a while
+	next := func() *Config {
+		select {
+		case config := <-applied:
+			return config
+		case <-time.After(200 * time.Millisecond):
+			return nil
+		}
+	}
+	setFailing := func(fail bool) {
+		<-failing
+		failing <- fail
+	}
+
+	if config := next(); config != nil {
+		t.Errorf("unchanged config was applied: %+v", config)
+	}
+	writeFile(t, dir, "config.yaml", setupConfig("team-payments"))
+	if config := next(); config == nil || config.Setups[0].ID != "team-payments" {
+		t.Fatalf("applied %+v, want the changed config", config)
+	}
+	if testutil.ToFloat64(configLoaded) != 1 {
+		t.Errorf("changed config is not reported as loaded")
+	}
+
+	writeFile(t, dir, "config.yaml", "setups: []\n")
+	if config := next(); config != nil {
+		t.Errorf("invalid config was applied: %+v", config)
+	}
+	if testutil.ToFloat64(configLoaded) != 0 {
+		t.Errorf("invalid config is reported as loaded")
+	}
+
+	setFailing(true)
+	writeFile(t, dir, "config.yaml", setupConfig("team-orders"))
+	if config := next(); config != nil {
+		t.Errorf("config was applied although apply failed: %+v", config)
+	}
+	if testutil.ToFloat64(configLoaded) != 0 {
+		t.Errorf("config which failed to apply is reported as loaded")
+	}
+	setFailing(false)
+	if config := next(); config == nil || config.Setups[0].ID != "team-orders" {
+		t.Errorf("applied %+v, want the config which failed to apply before", config)
+	}
+}
@@ -0,0 +1,222 @@
+package sink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "alertmanager-config-controller"
+)
+
+// Sink publishes the rendered alertmanager.yml
+type Sink interface {
+	// Write replaces the published alertmanager.yml
+	Write(config []byte) error
+	// Read returns the published alertmanager.yml
+	Read() ([]byte, error)
+	// Remove withdraws the published alertmanager.yml
+	Remove() error
+	// String describes where alertmanager.yml is published
+	String() string
+}
+
+// File writes alertmanager.yml to the local filesystem
+type File struct {
+	Path string
+}
+
+// NewFile returns a Sink writing to path
+func NewFile(path string) *File {
+	return &File{Path: path}
+}
+
+// Write alertmanager.yml to the file
+func (f *File) Write(config []byte) error {
+	return ioutil.WriteFile(f.Path, config, 0644)
+}
+
+// Read alertmanager.yml from the file
+func (f *File) Read() ([]byte, error) {
+	return ioutil.ReadFile(f.Path)
+}
+
+// Remove the file, a missing file is no error
+func (f *File) Remove() error {
+	err := os.Remove(f.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f *File) String() string {
+	return "file " + f.Path
+}
+
+// Secret stores alertmanager.yml under a key of a Kubernetes secret
+type Secret struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// NewSecret returns a Sink writing to key of the secret namespace/name
+func NewSecret(client kubernetes.Interface, namespace string, name string, key string) *Secret {
+	return &Secret{
+		Client:    client,
+		Namespace: namespace,
+		Name:      name,
+		Key:       key,
+	}
+}
+
+// Write alertmanager.yml to the secret, which is created if it does not exist
+func (s *Secret) Write(config []byte) error {
+	secrets := s.Client.CoreV1().Secrets(s.Namespace)
+	secret, err := secrets.Get(s.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.Name,
+				Namespace: s.Namespace,
+				Labels:    map[string]string{managedByLabel: managedByValue},
+			},
+			Data: map[string][]byte{s.Key: config},
+		}
+		_, err = secrets.Create(secret)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[s.Key] = config
+	_, err = secrets.Update(secret)
+	return err
+}
+
+// Read alertmanager.yml from the secret
+func (s *Secret) Read() ([]byte, error) {
+	secret, err := s.Client.CoreV1().Secrets(s.Namespace).Get(s.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	config, ok := secret.Data[s.Key]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in %s", s.Key, s)
+	}
+	return config, nil
+}
+
+// Remove the key of alertmanager.yml from the secret, the secret itself is kept
+func (s *Secret) Remove() error {
+	secrets := s.Client.CoreV1().Secrets(s.Namespace)
+	secret, err := secrets.Get(s.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := secret.Data[s.Key]; !ok {
+		return nil
+	}
+	delete(secret.Data, s.Key)
+	_, err = secrets.Update(secret)
+	return err
+}
+
+func (s *Secret) String() string {
+	return "secret " + s.Namespace + "/" + s.Name
+}
+
+// ConfigMap stores alertmanager.yml under a key of a Kubernetes configmap
+type ConfigMap struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// NewConfigMap returns a Sink writing to key of the configmap namespace/name
+func NewConfigMap(client kubernetes.Interface, namespace string, name string, key string) *ConfigMap {
+	return &ConfigMap{
+		Client:    client,
+		Namespace: namespace,
+		Name:      name,
+		Key:       key,
+	}
+}
+
+// Write alertmanager.yml to the configmap, which is created if it does not exist
+func (c *ConfigMap) Write(config []byte) error {
+	configMaps := c.Client.CoreV1().ConfigMaps(c.Namespace)
+	configMap, err := configMaps.Get(c.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.Name,
+				Namespace: c.Namespace,
+				Labels:    map[string]string{managedByLabel: managedByValue},
+			},
+			Data: map[string]string{c.Key: string(config)},
+		}
+		_, err = configMaps.Create(configMap)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[c.Key] = string(config)
+	_, err = configMaps.Update(configMap)
+	return err
+}
+
+// Read alertmanager.yml from the configmap
+func (c *ConfigMap) Read() ([]byte, error) {
+	configMap, err := c.Client.CoreV1().ConfigMaps(c.Namespace).Get(c.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	config, ok := configMap.Data[c.Key]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in %s", c.Key, c)
+	}
+	return []byte(config), nil
+}
+
+// Remove the key of alertmanager.yml from the configmap, the configmap itself is kept
+func (c *ConfigMap) Remove() error {
+	configMaps := c.Client.CoreV1().ConfigMaps(c.Namespace)
+	configMap, err := configMaps.Get(c.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := configMap.Data[c.Key]; !ok {
+		return nil
+	}
+	delete(configMap.Data, c.Key)
+	_, err = configMaps.Update(configMap)
+	return err
+}
+
+func (c *ConfigMap) String() string {
+	return "configmap " + c.Namespace + "/" + c.Name
+}
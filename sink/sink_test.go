@@ -0,0 +1,182 @@
+package sink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// a sink and the object it publishes to, if it exists before the test
+type sinkCase struct {
+	name     string
+	existing []runtime.Object
+	sink     func(client *fake.Clientset) Sink
+	// labels of the object after the first write
+	labels map[string]string
+	// value of another key of the object, which must survive
+	other string
+}
+
+func sinkCases() []sinkCase {
+	secret := func(client *fake.Clientset) Sink { return NewSecret(client, "monitoring", "am", "alertmanager.yml") }
+	configMap := func(client *fake.Clientset) Sink {
+		return NewConfigMap(client, "monitoring", "am", "alertmanager.yml")
+	}
+	managed := map[string]string{managedByLabel: managedByValue}
+	return []sinkCase{
+		{name: "new secret", sink: secret, labels: managed},
+		{
+			name: "existing secret",
+			existing: []runtime.Object{&v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "am"},
+				Data:       map[string][]byte{"other": []byte("kept")},
+			}},
+			sink:  secret,
+			other: "kept",
+		},
+		{
+			name:     "existing secret without data",
+			existing: []runtime.Object{&v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "am"}}},
+			sink:     secret,
+		},
+		{name: "new configmap", sink: configMap, labels: managed},
+		{
+			name: "existing configmap",
+			existing: []runtime.Object{&v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "am"},
+				Data:       map[string]string{"other": "kept"},
+			}},
+			sink:  configMap,
+			other: "kept",
+		},
+		{
+			name:     "existing configmap without data",
+			existing: []runtime.Object{&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "am"}}},
+			sink:     configMap,
+		},
+	}
+}
+
+// labels and the other key of the object behind the sink
+func inspect(t *testing.T, client *fake.Clientset, s Sink) (map[string]string, string) {
+	switch s.(type) {
+	case *Secret:
+		secret, err := client.CoreV1().Secrets("monitoring").Get("am", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return secret.Labels, string(secret.Data["other"])
+	default:
+		configMap, err := client.CoreV1().ConfigMaps("monitoring").Get("am", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return configMap.Labels, configMap.Data["other"]
+	}
+}
+
+func TestKubernetesSinks(t *testing.T) {
+	for _, tc := range sinkCases() {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(tc.existing...)
+			s := tc.sink(client)
+
+			if _, err := s.Read(); err == nil {
+				t.Errorf("Read before the first write succeeded")
+			}
+			for _, config := range []string{"first", "second"} {
+				if err := s.Write([]byte(config)); err != nil {
+					t.Fatal(err)
+				}
+				got, err := s.Read()
+				if err != nil || string(got) != config {
+					t.Errorf("Read = %q, %v, want %q", got, err, config)
+				}
+			}
+			labels, other := inspect(t, client, s)
+			if labels[managedByLabel] != tc.labels[managedByLabel] {
+				t.Errorf("labels = %v, want %v", labels, tc.labels)
+			}
+			if other != tc.other {
+				t.Errorf("other key = %q, want %q", other, tc.other)
+			}
+
+			for i := 0; i < 2; i++ {
+				if err := s.Remove(); err != nil {
+					t.Fatalf("Remove: %v", err)
+				}
+			}
+			if _, err := s.Read(); err == nil {
+				t.Errorf("Read after Remove succeeded")
+			}
+			if _, other = inspect(t, client, s); other != tc.other {
+				t.Errorf("other key after Remove = %q, want %q", other, tc.other)
+			}
+		})
+	}
+}
+
+func TestRemoveWithoutObject(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	for _, s := range []Sink{
+		NewSecret(client, "monitoring", "am", "alertmanager.yml"),
+		NewConfigMap(client, "monitoring", "am", "alertmanager.yml"),
+	} {
+		if err := s.Remove(); err != nil {
+			t.Errorf("%s: Remove = %v, want no error", s, err)
+		}
+		if _, err := s.Read(); !errors.IsNotFound(err) {
+			t.Errorf("%s: Read = %v, want not found", s, err)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := NewFile(filepath.Join(dir, "alertmanager.yml"))
+
+	if _, err := s.Read(); err == nil {
+		t.Errorf("Read before the first write succeeded")
+	}
+	if err := s.Write([]byte("config")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Read(); err != nil || string(got) != "config" {
+		t.Errorf("Read = %q, %v, want config", got, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.Remove(); err != nil {
+			t.Fatalf("Remove: %v", err)
+		}
+	}
+	if _, err := s.Read(); !os.IsNotExist(err) {
+		t.Errorf("Read after Remove = %v, want not exist", err)
+	}
+}
+
+func TestString(t *testing.T) {
+	cases := []struct {
+		sink Sink
+		want string
+	}{
+		{NewFile("/etc/config/alertmanager.yml"), "file /etc/config/alertmanager.yml"},
+		{NewSecret(nil, "monitoring", "am", "alertmanager.yml"), "secret monitoring/am"},
+		{NewConfigMap(nil, "monitoring", "am", "alertmanager.yml"), "configmap monitoring/am"},
+	}
+	for _, tc := range cases {
+		if got := tc.sink.String(); got != tc.want {
+			t.Errorf("String() = %q, want %q", got, tc.want)
+		}
+	}
+}